@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"syscall"
+
+	"github.com/Reidond/ccdbind/internal/config"
+	"github.com/Reidond/ccdbind/internal/procscan"
+	"github.com/Reidond/ccdbind/internal/topology"
+)
+
+// applyAffinity pins every thread of the game's processes to the GAME CPUs
+// with sched_setaffinity, leaving their cgroups untouched. Threads already
+// pinned for the same process incarnation are skipped, so each tick only
+// touches threads spawned since the last one.
+func applyAffinity(r *runtime, gameID string, procs []procscan.GameProcess) error {
+	_, cpus, err := topology.CanonicalizeCPUList(r.gameCPUs)
+	if err != nil {
+		return fmt.Errorf("game cpus %q: %w", r.gameCPUs, err)
+	}
+
+	applied := 0
+	for _, gp := range procs {
+		rec, ok := r.pidToUnit[gp.PID]
+		if !ok || rec.unit != "" || rec.startTime != gp.StartTime || rec.tids == nil {
+			rec = pidRecord{gameID: gameID, startTime: gp.StartTime, tids: map[int]struct{}{}}
+		}
+
+		tids, err := procscan.ThreadIDs(gp.PID)
+		if err != nil {
+			// The process exited between scan and now.
+			continue
+		}
+		live := make(map[int]struct{}, len(tids))
+		for _, tid := range tids {
+			live[tid] = struct{}{}
+			if _, done := rec.tids[tid]; done {
+				continue
+			}
+			if r.dryRun {
+				log.Printf("dry-run: sched_setaffinity(%d) cpus=%s", tid, r.gameCPUs)
+			} else if err := procscan.SetAffinity(tid, cpus); err != nil {
+				if errors.Is(err, syscall.ESRCH) {
+					continue
+				}
+				return fmt.Errorf("sched_setaffinity pid=%d tid=%d: %w", gp.PID, tid, err)
+			}
+			rec.tids[tid] = struct{}{}
+			applied++
+		}
+		for tid := range rec.tids {
+			if _, ok := live[tid]; !ok {
+				delete(rec.tids, tid)
+			}
+		}
+		r.pidToUnit[gp.PID] = rec
+	}
+	if applied > 0 {
+		log.Printf("game %s: set affinity on %d threads to %s", gameID, applied, r.gameCPUs)
+	}
+	return nil
+}
+
+// affinityHostSlices returns the pinned slices that contain processes of an
+// affinity-mode game. Pinning such a slice to the OS CPUs would confine the
+// game there too, since a thread's affinity is clipped to its cpuset.
+func affinityHostSlices(r *runtime, slices []string, games map[string][]procscan.GameProcess) map[string]struct{} {
+	out := map[string]struct{}{}
+	for gameID, procs := range games {
+		if r.cfg.ModeFor(gameID) != config.ModeAffinity {
+			continue
+		}
+		for _, gp := range procs {
+			for _, unit := range slices {
+				if procscan.CgroupInUnit(gp.Cgroup, unit) {
+					out[unit] = struct{}{}
+				}
+			}
+		}
+	}
+	return out
+}
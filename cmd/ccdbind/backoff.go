@@ -0,0 +1,36 @@
+package main
+
+import "time"
+
+const (
+	backoffMin = 2 * time.Second
+	backoffMax = 5 * time.Minute
+)
+
+// backoff spaces out retries of a failing systemd operation: the delay
+// doubles with each consecutive failure, up to backoffMax.
+type backoff struct {
+	failures int
+	next     time.Time
+}
+
+func (b *backoff) ready(now time.Time) bool {
+	return b.failures == 0 || !now.Before(b.next)
+}
+
+// fail records a failure at now and returns the delay until the next attempt.
+func (b *backoff) fail(now time.Time) time.Duration {
+	delay := backoffMin << b.failures
+	if delay > backoffMax || delay <= 0 {
+		delay = backoffMax
+	} else {
+		b.failures++
+	}
+	b.next = now.Add(delay)
+	return delay
+}
+
+func (b *backoff) reset() {
+	b.failures = 0
+	b.next = time.Time{}
+}
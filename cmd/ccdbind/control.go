@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+
+	"github.com/Reidond/ccdbind/internal/config"
+	"github.com/Reidond/ccdbind/internal/control"
+	"github.com/Reidond/ccdbind/internal/procscan"
+	"github.com/Reidond/ccdbind/internal/state"
+	"github.com/Reidond/ccdbind/internal/systemdctl"
+)
+
+// controlServer exports the daemon API on the user bus. D-Bus calls arrive on
+// godbus goroutines; each one is handed to the main loop through reqs so the
+// handlers can touch daemon state without locking.
+type controlServer struct {
+	conn *dbus.Conn
+	reqs chan<- func()
+
+	status       func() control.Status
+	pause        func(until time.Time) error
+	resume       func() error
+	forceRestore func() error
+	pinPID       func(int) error
+	unpinPID     func(int) error
+	pinGame      func(string) error
+	unpinGame    func(string) error
+	launcher     func(pid int, gameID string) error
+	unlauncher   func(pid int)
+	reload       func() error
+}
+
+// controlTimeout bounds both the wait for the main loop to take a request
+// and the wait for its result. Tests shorten it.
+var controlTimeout = 30 * time.Second
+
+func startControl(srv *controlServer) error {
+	conn, err := systemdctl.ConnectUserBus()
+	if err != nil {
+		return err
+	}
+	if err := conn.ExportMethodTable(map[string]any{
+		"GetStatus":    srv.GetStatus,
+		"ListGames":    srv.ListGames,
+		"PauseUntil":   srv.PauseUntil,
+		"ForceRestore": srv.ForceRestore,
+		"PinPID":       srv.PinPID,
+		"UnpinPID":     srv.UnpinPID,
+		"PinGame":      srv.PinGame,
+		"UnpinGame":    srv.UnpinGame,
+		"ReloadConfig": srv.ReloadConfig,
+
+		"RegisterLauncher":   srv.RegisterLauncher,
+		"UnregisterLauncher": srv.UnregisterLauncher,
+	}, control.ObjectPath, control.Interface); err != nil {
+		conn.Close()
+		return err
+	}
+	if err := conn.Export(introspectable(control.IntrospectXML), control.ObjectPath, "org.freedesktop.DBus.Introspectable"); err != nil {
+		conn.Close()
+		return err
+	}
+	reply, err := conn.RequestName(control.BusName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		conn.Close()
+		return fmt.Errorf("%s is already owned (another ccdbind running?)", control.BusName)
+	}
+	srv.conn = conn
+	return nil
+}
+
+func (s *controlServer) Close() {
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
+
+// do runs fn on the main loop and waits for it.
+func (s *controlServer) do(fn func() error) *dbus.Error {
+	done := make(chan error, 1)
+	timeout := time.NewTimer(controlTimeout)
+	defer timeout.Stop()
+	select {
+	case s.reqs <- func() { done <- fn() }:
+	case <-timeout.C:
+		return dbus.MakeFailedError(fmt.Errorf("daemon busy"))
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			return dbus.MakeFailedError(err)
+		}
+		return nil
+	case <-timeout.C:
+		return dbus.MakeFailedError(fmt.Errorf("timed out"))
+	}
+}
+
+func (s *controlServer) GetStatus() (string, *dbus.Error) {
+	var st control.Status
+	if derr := s.do(func() error { st = s.status(); return nil }); derr != nil {
+		return "", derr
+	}
+	b, _ := json.Marshal(st)
+	return string(b), nil
+}
+
+func (s *controlServer) ListGames() (string, *dbus.Error) {
+	var games []control.Game
+	if derr := s.do(func() error { games = s.status().Games; return nil }); derr != nil {
+		return "", derr
+	}
+	b, _ := json.Marshal(games)
+	return string(b), nil
+}
+
+// PauseUntil pauses until the given unix time; 0 resumes and a negative
+// value pauses until resumed.
+func (s *controlServer) PauseUntil(unix int64) *dbus.Error {
+	switch {
+	case unix == 0:
+		return s.do(s.resume)
+	case unix < 0:
+		return s.do(func() error { return s.pause(time.Time{}) })
+	default:
+		return s.do(func() error { return s.pause(time.Unix(unix, 0)) })
+	}
+}
+
+func (s *controlServer) ForceRestore() *dbus.Error {
+	return s.do(s.forceRestore)
+}
+
+func (s *controlServer) PinPID(pid uint32) *dbus.Error {
+	return s.do(func() error { return s.pinPID(int(pid)) })
+}
+
+func (s *controlServer) UnpinPID(pid uint32) *dbus.Error {
+	return s.do(func() error { return s.unpinPID(int(pid)) })
+}
+
+func (s *controlServer) PinGame(gameID string) *dbus.Error {
+	return s.do(func() error { return s.pinGame(gameID) })
+}
+
+func (s *controlServer) UnpinGame(gameID string) *dbus.Error {
+	return s.do(func() error { return s.unpinGame(gameID) })
+}
+
+func (s *controlServer) RegisterLauncher(pid uint32, gameID string) *dbus.Error {
+	return s.do(func() error { return s.launcher(int(pid), gameID) })
+}
+
+func (s *controlServer) UnregisterLauncher(pid uint32) *dbus.Error {
+	return s.do(func() error { s.unlauncher(int(pid)); return nil })
+}
+
+func (s *controlServer) ReloadConfig() *dbus.Error {
+	return s.do(s.reload)
+}
+
+func (s *controlServer) emit(name string, args ...any) {
+	if s == nil || s.conn == nil {
+		return
+	}
+	if err := s.conn.Emit(control.ObjectPath, name, args...); err != nil {
+		log.Printf("emit %s: %v", name, err)
+	}
+}
+
+type introspectable string
+
+func (i introspectable) Introspect() (string, *dbus.Error) {
+	return string(i), nil
+}
+
+// activeGame is a game the daemon has seen in the most recent tick.
+type activeGame struct {
+	id        string
+	name      string
+	unit      string
+	mode      string
+	startedAt time.Time
+	pids      []int
+
+	// Session bookkeeping for the history log.
+	pidsSeen     map[int]struct{}
+	placed       bool
+	slicesPinned bool
+	reapplies    int
+	errors       []string
+	placement    *placementSampler
+}
+
+// scope returns the game's scope unit, or "" when it is not placed in one.
+func (g *activeGame) scope() string {
+	if g.mode != config.ModeScope {
+		return ""
+	}
+	return g.unit
+}
+
+// trackGames updates r.active from the latest scan and returns the games
+// that appeared and disappeared, sorted by ID.
+func (r *runtime) trackGames(games map[string][]procscan.GameProcess, now time.Time) (started, stopped []*activeGame) {
+	for id, procs := range games {
+		g, ok := r.active[id]
+		if !ok {
+			g = &activeGame{id: id, name: r.gameName(id, procs), unit: systemdctl.UnitNameForGameID(id), mode: r.cfg.ModeFor(id), startedAt: now, pidsSeen: map[int]struct{}{}}
+			r.active[id] = g
+			started = append(started, g)
+		}
+		if len(procs) == 0 {
+			// Held by stop_grace; keep the last known PIDs for hooks.
+			continue
+		}
+		g.pids = g.pids[:0]
+		for _, gp := range procs {
+			g.pids = append(g.pids, gp.PID)
+			if len(g.pidsSeen) < maxSessionPIDs {
+				g.pidsSeen[gp.PID] = struct{}{}
+			}
+		}
+		sort.Ints(g.pids)
+	}
+	for id, g := range r.active {
+		if _, ok := games[id]; !ok {
+			stopped = append(stopped, g)
+			delete(r.active, id)
+		}
+	}
+	sort.Slice(started, func(i, j int) bool { return started[i].id < started[j].id })
+	sort.Slice(stopped, func(i, j int) bool { return stopped[i].id < stopped[j].id })
+	return started, stopped
+}
+
+// gameName is the profile name for id, else the executable of its lowest PID.
+func (r *runtime) gameName(id string, procs []procscan.GameProcess) string {
+	if p, ok := r.cfg.Games[id]; ok && p.Name != "" {
+		return p.Name
+	}
+	name, lowest := id, 0
+	for _, gp := range procs {
+		if gp.Exe != "" && (lowest == 0 || gp.PID < lowest) {
+			name, lowest = gp.Exe, gp.PID
+		}
+	}
+	return name
+}
+
+func (r *runtime) recordTick(start time.Time, err error) {
+	r.lastTick = start
+	r.lastTickDur = time.Since(start)
+	if err != nil {
+		r.lastErr = err.Error()
+		r.lastErrAt = start
+	}
+}
+
+func (r *runtime) snapshot(be systemdctl.Backend, st state.File) control.Status {
+	out := control.Status{
+		PID:            os.Getpid(),
+		StartedAt:      r.startedAt,
+		Backend:        be.Name(),
+		DryRun:         r.dryRun,
+		OSCPUs:         r.osCPUs,
+		GameCPUs:       r.gameCPUs,
+		PinApplied:     st.PinApplied,
+		Paused:         r.ov.IsPaused(time.Now()),
+		PausedUntil:    r.ov.PausedUntil,
+		LastTick:       r.lastTick,
+		LastTickMillis: float64(r.lastTickDur.Microseconds()) / 1000,
+		LastError:      r.lastErr,
+		LastErrorAt:    r.lastErrAt,
+		Pending:        r.pending(),
+
+		Launchers:          r.launcherStatus(),
+		SlicesHeldByCcdpin: r.slicesHeldByCcdpin,
+	}
+
+	procs := map[string][]control.Proc{}
+	for pid, rec := range r.pidToUnit {
+		procs[rec.gameID] = append(procs[rec.gameID], control.Proc{PID: pid, StartTime: rec.startTime, Unit: rec.unit, Threads: len(rec.tids)})
+	}
+	ids := make([]string, 0, len(r.active))
+	for id := range r.active {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		g := r.active[id]
+		ps := procs[id]
+		sort.Slice(ps, func(i, j int) bool { return ps[i].PID < ps[j].PID })
+		out.Games = append(out.Games, control.Game{ID: id, Unit: g.scope(), Mode: g.mode, StartedAt: g.startedAt, Procs: ps, Placement: g.placementSummary()})
+	}
+	return out
+}
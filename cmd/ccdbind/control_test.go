@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// serveReqs runs the requests sent to srv the way the main loop does, until
+// the test ends.
+func serveReqs(t *testing.T) chan func() {
+	t.Helper()
+	reqs := make(chan func())
+	stop := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case fn := <-reqs:
+				fn()
+			case <-stop:
+				return
+			}
+		}
+	}()
+	t.Cleanup(func() { close(stop) })
+	return reqs
+}
+
+func shortControlTimeout(t *testing.T) {
+	prev := controlTimeout
+	controlTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { controlTimeout = prev })
+}
+
+func dbusErrorText(derr *dbus.Error) string {
+	if derr == nil {
+		return ""
+	}
+	return derr.Error()
+}
+
+func TestControlDo(t *testing.T) {
+	shortControlTimeout(t)
+	srv := &controlServer{reqs: serveReqs(t)}
+
+	ran := false
+	if derr := srv.do(func() error { ran = true; return nil }); derr != nil || !ran {
+		t.Fatalf("do: ran=%v err=%v", ran, derr)
+	}
+	derr := srv.do(func() error { return errors.New("boom") })
+	if !strings.Contains(dbusErrorText(derr), "boom") {
+		t.Fatalf("handler error not returned: %v", derr)
+	}
+
+	// The main loop took the request but did not finish in time.
+	release := make(chan struct{})
+	defer close(release)
+	derr = srv.do(func() error { <-release; return nil })
+	if !strings.Contains(dbusErrorText(derr), "timed out") {
+		t.Fatalf("expected timed out, got %v", derr)
+	}
+}
+
+func TestControlDoBusy(t *testing.T) {
+	shortControlTimeout(t)
+	// Nothing reads the requests, as while the main loop is stuck in a tick.
+	srv := &controlServer{reqs: make(chan func())}
+	ran := false
+	derr := srv.do(func() error { ran = true; return nil })
+	if !strings.Contains(dbusErrorText(derr), "daemon busy") || ran {
+		t.Fatalf("expected daemon busy, got %v (ran=%v)", derr, ran)
+	}
+}
+
+func TestControlPauseUntil(t *testing.T) {
+	var calls []string
+	var until time.Time
+	srv := &controlServer{
+		reqs:   serveReqs(t),
+		pause:  func(u time.Time) error { calls = append(calls, "pause"); until = u; return nil },
+		resume: func() error { calls = append(calls, "resume"); return nil },
+	}
+
+	if derr := srv.PauseUntil(0); derr != nil || len(calls) != 1 || calls[0] != "resume" {
+		t.Fatalf("PauseUntil(0): calls=%v err=%v", calls, derr)
+	}
+	if derr := srv.PauseUntil(-1); derr != nil || len(calls) != 2 || calls[1] != "pause" || !until.IsZero() {
+		t.Fatalf("PauseUntil(-1): calls=%v until=%v err=%v", calls, until, derr)
+	}
+	if derr := srv.PauseUntil(1_700_000_000); derr != nil || len(calls) != 3 || calls[2] != "pause" || !until.Equal(time.Unix(1_700_000_000, 0)) {
+		t.Fatalf("PauseUntil(t): calls=%v until=%v err=%v", calls, until, derr)
+	}
+}
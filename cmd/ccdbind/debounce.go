@@ -0,0 +1,98 @@
+package main
+
+import (
+	"log"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/control"
+	"github.com/Reidond/ccdbind/internal/procscan"
+)
+
+// settle applies start_delay and stop_grace to a scan result before it
+// reaches handleTick. New games are held back until they have been seen for
+// StartDelay. Active games that vanished keep an empty entry, which keeps
+// the slices pinned, until StopGrace has passed; a launcher that relaunches
+// its game within the grace therefore causes no restore/re-pin cycle.
+// Manually pinned PIDs and games, and games a registered ccdpin is
+// launching, skip the start delay.
+func (r *runtime) settle(games map[string][]procscan.GameProcess, now time.Time) map[string][]procscan.GameProcess {
+	out := make(map[string][]procscan.GameProcess, len(games))
+	for id, procs := range games {
+		if _, ok := r.goneAt[id]; ok {
+			log.Printf("game %s is back within stop_grace", id)
+			delete(r.goneAt, id)
+		}
+		if _, ok := r.active[id]; ok || r.cfg.StartDelay <= 0 || isManual(procs) || slices.Contains(r.ov.PinnedGames, id) || r.launchedGame(id) {
+			delete(r.seenAt, id)
+			out[id] = procs
+			continue
+		}
+		first, ok := r.seenAt[id]
+		if !ok {
+			first = now
+			r.seenAt[id] = now
+			log.Printf("game %s seen; placing after start_delay=%s", id, r.cfg.StartDelay)
+		}
+		if now.Sub(first) >= r.cfg.StartDelay {
+			delete(r.seenAt, id)
+			out[id] = procs
+		}
+	}
+	for id := range r.seenAt {
+		if _, ok := games[id]; !ok {
+			delete(r.seenAt, id)
+		}
+	}
+
+	for id := range r.active {
+		if _, ok := games[id]; ok || r.cfg.StopGrace <= 0 {
+			continue
+		}
+		gone, ok := r.goneAt[id]
+		if !ok {
+			gone = now
+			r.goneAt[id] = now
+			log.Printf("game %s exited; holding pin for stop_grace=%s", id, r.cfg.StopGrace)
+		}
+		if now.Sub(gone) < r.cfg.StopGrace {
+			out[id] = nil
+			continue
+		}
+		delete(r.goneAt, id)
+	}
+	for id := range r.goneAt {
+		if _, ok := r.active[id]; !ok {
+			delete(r.goneAt, id)
+		}
+	}
+	return out
+}
+
+func isManual(procs []procscan.GameProcess) bool {
+	for _, gp := range procs {
+		if gp.IDSource == "manual" {
+			return true
+		}
+	}
+	return false
+}
+
+// pending lists the transitions settle is currently holding back.
+func (r *runtime) pending() []control.Pending {
+	var out []control.Pending
+	for id, at := range r.seenAt {
+		out = append(out, control.Pending{GameID: id, Transition: "start", Since: at, Due: at.Add(r.cfg.StartDelay)})
+	}
+	for id, at := range r.goneAt {
+		out = append(out, control.Pending{GameID: id, Transition: "stop", Since: at, Due: at.Add(r.cfg.StopGrace)})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].GameID != out[j].GameID {
+			return out[i].GameID < out[j].GameID
+		}
+		return out[i].Transition < out[j].Transition
+	})
+	return out
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/config"
+	"github.com/Reidond/ccdbind/internal/procscan"
+	"github.com/Reidond/ccdbind/internal/state"
+)
+
+// settleStep is one tick: whether the game's process is seen at t, and
+// what settle passes on for it.
+type settleStep struct {
+	t    time.Duration
+	seen bool
+	want string // "" not passed on, "place" with its processes, "hold" pinned without any
+}
+
+func newSettleRuntime(startDelay, stopGrace time.Duration) *runtime {
+	cfg := config.Default()
+	cfg.StartDelay, cfg.StopGrace = startDelay, stopGrace
+	return &runtime{
+		cfg:       cfg,
+		active:    map[string]*activeGame{},
+		ov:        &state.Overrides{},
+		seenAt:    map[string]time.Time{},
+		goneAt:    map[string]time.Time{},
+		launchers: map[int]launcher{},
+	}
+}
+
+// runSettle feeds the steps through settle, activating and deactivating the
+// game the way handleTick does with settle's output.
+func runSettle(t *testing.T, r *runtime, procs []procscan.GameProcess, steps []settleStep) {
+	t.Helper()
+	base := time.Unix(1_700_000_000, 0)
+	for _, st := range steps {
+		games := map[string][]procscan.GameProcess{}
+		if st.seen {
+			games["570"] = procs
+		}
+		out := r.settle(games, base.Add(st.t))
+		got, ok := out["570"]
+		var kind string
+		switch {
+		case ok && got == nil:
+			kind = "hold"
+		case ok:
+			kind = "place"
+		}
+		if kind != st.want {
+			t.Fatalf("at %v (seen=%v): got %q, want %q", st.t, st.seen, kind, st.want)
+		}
+		if ok {
+			r.active["570"] = &activeGame{id: "570"}
+		} else {
+			delete(r.active, "570")
+		}
+	}
+}
+
+func TestSettle(t *testing.T) {
+	s := time.Second
+	tests := []struct {
+		name       string
+		startDelay time.Duration
+		stopGrace  time.Duration
+		steps      []settleStep
+	}{
+		{
+			name: "no delay or grace",
+			steps: []settleStep{
+				{0, true, "place"},
+				{1 * s, false, ""},
+			},
+		},
+		{
+			name:       "appear after start_delay",
+			startDelay: 5 * s,
+			steps: []settleStep{
+				{0, true, ""},
+				{3 * s, true, ""},
+				{5 * s, true, "place"},
+				{6 * s, true, "place"},
+			},
+		},
+		{
+			name:       "flap within start_delay restarts it",
+			startDelay: 5 * s,
+			steps: []settleStep{
+				{0, true, ""},
+				{2 * s, false, ""},
+				{3 * s, true, ""},
+				{7 * s, true, ""},
+				{8 * s, true, "place"},
+			},
+		},
+		{
+			name:      "disappear after stop_grace",
+			stopGrace: 5 * s,
+			steps: []settleStep{
+				{0, true, "place"},
+				{1 * s, false, "hold"},
+				{5 * s, false, "hold"},
+				{6 * s, false, ""},
+				{7 * s, false, ""},
+			},
+		},
+		{
+			name:      "flap within stop_grace keeps the pin",
+			stopGrace: 5 * s,
+			steps: []settleStep{
+				{0, true, "place"},
+				{1 * s, false, "hold"},
+				{3 * s, true, "place"},
+				{4 * s, false, "hold"},
+				{8 * s, false, "hold"},
+				{9 * s, false, ""},
+			},
+		},
+		{
+			name:       "relaunch after stop_grace waits for start_delay again",
+			startDelay: 2 * s,
+			stopGrace:  2 * s,
+			steps: []settleStep{
+				{0, true, ""},
+				{2 * s, true, "place"},
+				{3 * s, false, "hold"},
+				{5 * s, false, ""},
+				{6 * s, true, ""},
+				{8 * s, true, "place"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newSettleRuntime(tt.startDelay, tt.stopGrace)
+			runSettle(t, r, []procscan.GameProcess{{PID: 100, GameID: "570"}}, tt.steps)
+		})
+	}
+}
+
+func TestSettleManualSkipsStartDelay(t *testing.T) {
+	r := newSettleRuntime(time.Minute, 0)
+	runSettle(t, r, []procscan.GameProcess{{PID: 100, GameID: "570", IDSource: "manual"}}, []settleStep{{0, true, "place"}})
+
+	r = newSettleRuntime(time.Minute, 0)
+	r.ov.PinGame("570")
+	runSettle(t, r, []procscan.GameProcess{{PID: 100, GameID: "570"}}, []settleStep{{0, true, "place"}})
+
+	r = newSettleRuntime(time.Minute, 0)
+	r.launchers[42] = launcher{gameID: "570"}
+	runSettle(t, r, []procscan.GameProcess{{PID: 100, GameID: "570"}}, []settleStep{{0, true, "place"}})
+}
+
+func TestPending(t *testing.T) {
+	r := newSettleRuntime(5*time.Second, 3*time.Second)
+	base := time.Unix(1_700_000_000, 0)
+	r.active["10"] = &activeGame{id: "10"}
+	r.settle(map[string][]procscan.GameProcess{"20": {{PID: 1, GameID: "20"}}}, base)
+
+	got := r.pending()
+	if len(got) != 2 {
+		t.Fatalf("unexpected pending: %+v", got)
+	}
+	if got[0].GameID != "10" || got[0].Transition != "stop" || !got[0].Due.Equal(base.Add(3*time.Second)) {
+		t.Fatalf("unexpected stop: %+v", got[0])
+	}
+	if got[1].GameID != "20" || got[1].Transition != "start" || !got[1].Due.Equal(base.Add(5*time.Second)) {
+		t.Fatalf("unexpected start: %+v", got[1])
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/cgroupfs"
+	"github.com/Reidond/ccdbind/internal/config"
+	"github.com/Reidond/ccdbind/internal/control"
+	"github.com/Reidond/ccdbind/internal/pinstate"
+	"github.com/Reidond/ccdbind/internal/systemdctl"
+	"github.com/Reidond/ccdbind/internal/topology"
+)
+
+const (
+	doctorPass = "pass"
+	doctorWarn = "warn"
+	doctorFail = "fail"
+)
+
+const steamFlatpakID = "com.valvesoftware.Steam"
+
+type doctorCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+	Fix    string `json:"fix,omitempty"`
+}
+
+type doctorReport struct {
+	GeneratedAt time.Time     `json:"generated_at"`
+	Kernel      string        `json:"kernel,omitempty"`
+	ConfigPath  string        `json:"config_path"`
+	Backend     string        `json:"backend"`
+	OSCPUs      string        `json:"os_cpus,omitempty"`
+	GameCPUs    string        `json:"game_cpus,omitempty"`
+	Checks      []doctorCheck `json:"checks"`
+}
+
+func (r *doctorReport) add(name, status, detail, fix string) {
+	r.Checks = append(r.Checks, doctorCheck{Name: name, Status: status, Detail: detail, Fix: fix})
+}
+
+func (r *doctorReport) count(status string) int {
+	n := 0
+	for _, c := range r.Checks {
+		if c.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// runDoctor implements `ccdbind doctor`: it checks the environment for the
+// usual reasons pinning silently does nothing. It exits 1 if a check failed.
+func runDoctor(args []string) {
+	fs := flag.NewFlagSet("ccdbind doctor", flag.ExitOnError)
+	flagJSON := fs.Bool("json", false, "output JSON (for bug reports)")
+	flagConfig := fs.String("config", "", "config file path (TOML). Default: XDG config path")
+	_ = fs.Parse(args)
+
+	configPath := strings.TrimSpace(*flagConfig)
+	if configPath == "" {
+		p, err := config.DefaultConfigPath()
+		if err != nil {
+			fatal(err)
+		}
+		configPath = p
+	}
+
+	rep := doctorReport{GeneratedAt: time.Now(), ConfigPath: configPath, Kernel: kernelRelease()}
+	cfg := doctorConfig(&rep, configPath)
+	rep.Backend = cfg.Backend
+	doctorCgroupV2(&rep)
+	be := doctorBackend(&rep, cfg)
+	if be != nil {
+		defer be.Close()
+		doctorSlices(&rep, be, cfg)
+	}
+	if cfg.Backend != "cgroupfs" {
+		doctorGameSlice(&rep)
+	}
+	doctorTopology(&rep, cfg)
+	doctorCcdpinState(&rep)
+	doctorFlatpak(&rep)
+	doctorUserManager(&rep)
+	doctorDaemon(&rep)
+
+	if *flagJSON {
+		b, _ := json.MarshalIndent(rep, "", "  ")
+		fmt.Println(string(b))
+	} else {
+		printDoctorHuman(rep)
+	}
+	if rep.count(doctorFail) > 0 {
+		os.Exit(1)
+	}
+}
+
+func printDoctorHuman(rep doctorReport) {
+	for _, c := range rep.Checks {
+		fmt.Printf("[%s] %s: %s\n", strings.ToUpper(c.Status), c.Name, c.Detail)
+		if c.Fix != "" && c.Status != doctorPass {
+			for _, line := range strings.Split(c.Fix, "\n") {
+				fmt.Printf("       %s\n", line)
+			}
+		}
+	}
+	fmt.Printf("\n%d passed, %d warnings, %d failed\n", rep.count(doctorPass), rep.count(doctorWarn), rep.count(doctorFail))
+}
+
+func kernelRelease() string {
+	data, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// doctorConfig loads the config, falling back to the defaults so the other
+// checks still run.
+func doctorConfig(rep *doctorReport, path string) config.Config {
+	cfg, err := config.Load(path)
+	if err != nil {
+		rep.add("config", doctorFail, err.Error(), "Fix the config file, or start again from config.example.toml.")
+		return config.Default()
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		rep.add("config", doctorPass, path+" not found; using defaults", "")
+	} else {
+		rep.add("config", doctorPass, path, "")
+	}
+	return cfg
+}
+
+func doctorCgroupV2(rep *doctorReport) {
+	if _, err := os.Stat("/sys/fs/cgroup/cgroup.controllers"); err != nil {
+		rep.add("cgroup v2", doctorFail, "the unified cgroup v2 hierarchy is not mounted at /sys/fs/cgroup",
+			"Boot with systemd.unified_cgroup_hierarchy=1 (the default on current distributions).")
+		return
+	}
+	rep.add("cgroup v2", doctorPass, "unified hierarchy at /sys/fs/cgroup", "")
+}
+
+// doctorBackend connects the configured backend and checks that it can
+// enforce AllowedCPUs. It returns nil if the backend is unusable.
+func doctorBackend(rep *doctorReport, cfg config.Config) systemdctl.Backend {
+	be, err := newBackend(cfg, false)
+	if err != nil {
+		fix := "Run ccdbind inside a graphical/login session with a systemd user manager (systemctl --user status),\nor enable lingering: loginctl enable-linger $USER"
+		if cfg.Backend == "cgroupfs" {
+			fix = "Point cgroup_root at a cgroup v2 directory delegated to your user. Without it ccdbind uses\nyour user manager's cgroup (user@<uid>.service), so outside one cgroup_root is required."
+		}
+		rep.add("backend", doctorFail, err.Error(), fix)
+		return nil
+	}
+	if cb, ok := be.(*cgroupfs.Backend); ok {
+		rep.add("backend", doctorPass, "cgroupfs at "+cb.Root, "")
+	} else {
+		rep.add("backend", doctorPass, be.Name(), "")
+	}
+
+	if err := be.CheckCPUSet(); err != nil {
+		fix := "Delegate cpuset to user managers, then log out and back in:\n" +
+			"  sudo mkdir -p /etc/systemd/system/user@.service.d\n" +
+			"  printf '[Service]\\nDelegate=cpu cpuset io memory pids\\n' | sudo tee /etc/systemd/system/user@.service.d/delegate.conf\n" +
+			"  sudo systemctl daemon-reload"
+		if be.Name() == "cgroupfs" {
+			fix = "Enable cpuset in cgroup.subtree_control of every parent of cgroup_root, or delegate it via systemd."
+		}
+		rep.add("cpuset delegation", doctorFail, err.Error(), fix)
+	} else {
+		rep.add("cpuset delegation", doctorPass, "cpuset controller available", "")
+	}
+	return be
+}
+
+// doctorSlices reads AllowedCPUs of every slice the daemon would pin.
+func doctorSlices(rep *doctorReport, be systemdctl.Backend, cfg config.Config) {
+	var missing, found []string
+	for _, unit := range cfg.OSSlices() {
+		ctx, cancel := systemdctl.DefaultContext()
+		_, err := be.GetAllowedCPUs(ctx, unit)
+		cancel()
+		if err != nil {
+			missing = append(missing, unit)
+			continue
+		}
+		found = append(found, unit)
+	}
+	switch {
+	case len(found) == 0:
+		rep.add("slices", doctorFail, "none of "+strings.Join(cfg.OSSlices(), ", ")+" could be read",
+			"Check pin_slices in the config; `systemctl --user list-units --type=slice` lists the slices that exist.")
+	case len(missing) > 0:
+		rep.add("slices", doctorWarn, fmt.Sprintf("found %s; cannot read %s", strings.Join(found, ", "), strings.Join(missing, ", ")),
+			"Missing slices are skipped. Remove them from pin_slices (or set pin_session_slice = false) to silence this.")
+	default:
+		rep.add("slices", doctorPass, strings.Join(found, ", "), "")
+	}
+}
+
+// doctorGameSlice looks for the game.slice unit file shipped in
+// systemd/user. Without it systemd still creates the slice implicitly, but
+// without CPUAccounting.
+func doctorGameSlice(rep *doctorReport) {
+	dirs := []string{"/etc/systemd/user", "/usr/local/lib/systemd/user", "/usr/lib/systemd/user"}
+	if base := os.Getenv("XDG_CONFIG_HOME"); base != "" {
+		dirs = append([]string{filepath.Join(base, "systemd", "user")}, dirs...)
+	} else if home, err := os.UserHomeDir(); err == nil {
+		dirs = append([]string{filepath.Join(home, ".config", "systemd", "user")}, dirs...)
+	}
+	for _, dir := range dirs {
+		path := filepath.Join(dir, "game.slice")
+		if _, err := os.Stat(path); err == nil {
+			rep.add("game.slice", doctorPass, path, "")
+			return
+		}
+	}
+	rep.add("game.slice", doctorWarn, "game.slice unit file not installed; systemd creates it implicitly without CPU accounting",
+		"install -Dm644 systemd/user/game.slice ~/.config/systemd/user/game.slice && systemctl --user daemon-reload")
+}
+
+func doctorTopology(rep *doctorReport, cfg config.Config) {
+	if strings.TrimSpace(cfg.OSCPUsOverride) != "" && strings.TrimSpace(cfg.GameCPUsOverride) != "" {
+		osCPUs, gameCPUs, err := resolveCPUs(cfg)
+		if err != nil {
+			rep.add("topology", doctorFail, err.Error(), "Fix os_cpus/game_cpus in the config.")
+			return
+		}
+		rep.OSCPUs, rep.GameCPUs = osCPUs, gameCPUs
+		_, osList, _ := topology.CanonicalizeCPUList(osCPUs)
+		_, gameList, _ := topology.CanonicalizeCPUList(gameCPUs)
+		for _, cpu := range gameList {
+			if topology.ContainsCPU(osList, cpu) {
+				rep.add("topology", doctorWarn, fmt.Sprintf("os_cpus=%s and game_cpus=%s overlap", osCPUs, gameCPUs), "Use disjoint CPU sets.")
+				return
+			}
+		}
+		rep.add("topology", doctorPass, fmt.Sprintf("from config: os_cpus=%s game_cpus=%s", osCPUs, gameCPUs), "")
+		return
+	}
+
+	res, err := topology.Detect()
+	switch {
+	case err != nil:
+		rep.add("topology", doctorFail, err.Error(), "L3 topology is not exposed in sysfs; set os_cpus and game_cpus in the config.")
+	case res.GameCPUs == "":
+		rep.add("topology", doctorWarn, fmt.Sprintf("single L3 group %v (single-CCD CPU?); nothing to split", res.Lists),
+			"ccdbind has no effect here unless you set os_cpus and game_cpus in the config.")
+	default:
+		rep.OSCPUs, rep.GameCPUs = res.OSCPUs, res.GameCPUs
+		rep.add("topology", doctorPass, fmt.Sprintf("%d L3 groups: os_cpus=%s game_cpus=%s", len(res.Lists), res.OSCPUs, res.GameCPUs), "")
+	}
+}
+
+// doctorCcdpinState looks for ccdpin's slice-pin lock and refcount: a lock
+// held by nobody alive, or instances that died without restoring slices.
+func doctorCcdpinState(rep *doctorReport) {
+	store, err := pinstate.Default()
+	if err != nil {
+		rep.add("ccdpin state", doctorWarn, err.Error(), "")
+		return
+	}
+	if _, err := os.Stat(store.Path); errors.Is(err, os.ErrNotExist) {
+		rep.add("ccdpin state", doctorPass, "no ccdpin state", "")
+		return
+	}
+	st, err := store.Read()
+	if err != nil {
+		rep.add("ccdpin state", doctorFail, fmt.Sprintf("%s: %v", store.Path, err),
+			"Run `ccdpin --prune`; it moves the file aside and loads "+store.BackupPath()+".")
+		return
+	}
+
+	recorded := st.Instances
+	st.PruneDead()
+	var live, stale []string
+	for key := range recorded {
+		if _, ok := st.Instances[key]; ok {
+			live = append(live, key)
+		} else {
+			stale = append(stale, key)
+		}
+	}
+	sort.Strings(live)
+	sort.Strings(stale)
+	held := lockHeld(store.LockPath)
+
+	switch {
+	case len(live) == 0 && len(st.OriginalAllowedCPUs) > 0:
+		rep.add("ccdpin state", doctorWarn, fmt.Sprintf("no ccdpin running but %s still recorded as pinned (dead instances: %v)", strings.Join(st.Slices, ", "), stale),
+			"Run `ccdpin --prune`; ccdbind also restores them at startup (recover_ccdpin).")
+	case held && len(live) == 0:
+		rep.add("ccdpin state", doctorWarn, "ccdpin lock is held but no ccdpin instance is alive",
+			"Find the holder with `fuser "+store.LockPath+"`.")
+	case len(live) > 0:
+		rep.add("ccdpin state", doctorPass, fmt.Sprintf("%d ccdpin instance(s) running: %v", len(live), live), "")
+	default:
+		rep.add("ccdpin state", doctorPass, "no leftover locks", "")
+	}
+}
+
+// lockHeld reports whether another process holds an flock on path.
+func lockHeld(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		return errors.Is(err, syscall.EWOULDBLOCK)
+	}
+	_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	return false
+}
+
+// doctorFlatpak detects a Flatpak'd Steam. Its games run in their own PID
+// namespace, where ccdpin sees a namespaced /proc and no user manager.
+func doctorFlatpak(rep *doctorReport) {
+	if _, err := os.Stat("/.flatpak-info"); err == nil {
+		rep.add("flatpak", doctorFail, "ccdbind itself is running inside a Flatpak sandbox",
+			"Run ccdbind on the host, e.g. as the ccdbind.service user unit.")
+		return
+	}
+	var paths []string
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths,
+			filepath.Join(home, ".var", "app", steamFlatpakID),
+			filepath.Join(home, ".local", "share", "flatpak", "app", steamFlatpakID))
+	}
+	paths = append(paths, filepath.Join("/var/lib/flatpak/app", steamFlatpakID))
+	for _, p := range paths {
+		if _, err := os.Stat(p); err == nil {
+			rep.add("flatpak", doctorWarn, "Steam is installed as a Flatpak ("+p+")",
+				"ccdpin in launch options runs inside the sandbox (namespaced /proc, no user manager) and cannot pin slices.\nRely on the ccdbind daemon, which detects Flatpak games from the host.")
+			return
+		}
+	}
+	rep.add("flatpak", doctorPass, "Steam is not a Flatpak", "")
+}
+
+// doctorUserManager checks that the systemd user manager answers on the
+// session bus; ccdpin creates its game scope through it.
+func doctorUserManager(rep *doctorReport) {
+	mgr, err := systemdctl.NewUserManager(false)
+	if err != nil {
+		rep.add("user manager", doctorWarn, "cannot reach the systemd user manager: "+err.Error()+"; ccdpin falls back to CPU affinity without a scope",
+			"Make sure DBUS_SESSION_BUS_ADDRESS is set and systemd --user is running.")
+		return
+	}
+	mgr.Close()
+	rep.add("user manager", doctorPass, "systemd user manager reachable on the session bus", "")
+}
+
+func doctorDaemon(rep *doctorReport) {
+	c, err := control.Dial()
+	switch {
+	case errors.Is(err, control.ErrNotRunning):
+		rep.add("daemon", doctorWarn, "ccdbind is not running", "systemctl --user enable --now ccdbind.service")
+		return
+	case err != nil:
+		rep.add("daemon", doctorWarn, err.Error(), "")
+		return
+	}
+	defer c.Close()
+	ctx, cancel := systemdctl.DefaultContext()
+	defer cancel()
+	st, err := c.GetStatus(ctx)
+	if err != nil {
+		rep.add("daemon", doctorWarn, err.Error(), "")
+		return
+	}
+	detail := fmt.Sprintf("running pid=%d backend=%s", st.PID, st.Backend)
+	if st.DryRun {
+		rep.add("daemon", doctorWarn, detail+" in dry-run mode", "Drop --dry-run from ExecStart to let it pin.")
+		return
+	}
+	rep.add("daemon", doctorPass, detail, "")
+}
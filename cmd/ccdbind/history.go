@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/history"
+)
+
+const (
+	maxSessionPIDs   = 256
+	maxSessionErrors = 20
+)
+
+func (r *runtime) markPlaced(gameID string) {
+	if g := r.active[gameID]; g != nil {
+		g.placed = true
+	}
+}
+
+// sessionError keeps the most recent placement errors of a game's session.
+func (r *runtime) sessionError(gameID string, err error) {
+	g := r.active[gameID]
+	if g == nil {
+		return
+	}
+	g.errors = append(g.errors, err.Error())
+	if len(g.errors) > maxSessionErrors {
+		g.errors = g.errors[len(g.errors)-maxSessionErrors:]
+	}
+}
+
+// recordSession appends g's session to the history log.
+func (r *runtime) recordSession(g *activeGame, end time.Time, interrupted bool) {
+	if r.historyPath == "" || r.dryRun {
+		return
+	}
+	pids := make([]int, 0, len(g.pidsSeen))
+	for pid := range g.pidsSeen {
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+	s := history.Session{
+		GameID:       g.id,
+		Name:         g.name,
+		Mode:         g.mode,
+		Scope:        g.scope(),
+		Start:        g.startedAt,
+		End:          end,
+		PIDs:         pids,
+		OSCPUs:       r.osCPUs,
+		GameCPUs:     r.gameCPUs,
+		Placed:       g.placed,
+		SlicesPinned: g.slicesPinned,
+		Reapplies:    g.reapplies,
+		Errors:       g.errors,
+		Placement:    g.placementSummary(),
+		Interrupted:  interrupted,
+	}
+	if err := history.Append(r.historyPath, s, history.MaxBytes); err != nil {
+		log.Printf("history: %v", err)
+	}
+}
+
+// closeSessions records every active game as interrupted, on shutdown.
+func (r *runtime) closeSessions(now time.Time) {
+	ids := make([]string, 0, len(r.active))
+	for id := range r.active {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		r.recordSession(r.active[id], now, true)
+	}
+}
+
+type historySummary struct {
+	GameID    string        `json:"game_id"`
+	Name      string        `json:"name,omitempty"`
+	Sessions  int           `json:"sessions"`
+	Total     time.Duration `json:"total_ns"`
+	Placed    int           `json:"placed"`
+	Pinned    int           `json:"slices_pinned"`
+	Reapplies int           `json:"reapplies"`
+	Errors    int           `json:"errors"`
+	LastEnd   time.Time     `json:"last_end"`
+}
+
+type historyOutput struct {
+	Path     string            `json:"path"`
+	Since    time.Time         `json:"since,omitempty"`
+	Game     string            `json:"game,omitempty"`
+	Sessions []history.Session `json:"sessions"`
+	Summary  []historySummary  `json:"summary"`
+}
+
+func runHistory(args []string) {
+	fs := flag.NewFlagSet("ccdbind history", flag.ExitOnError)
+	var (
+		flagSince = fs.String("since", "", "only sessions that ended after this: a duration (24h) or a time (2006-01-02, RFC3339)")
+		flagGame  = fs.String("game", "", "only this game ID")
+		flagJSON  = fs.Bool("json", false, "output JSON")
+	)
+	_ = fs.Parse(args)
+
+	since, err := parseSince(*flagSince, time.Now())
+	if err != nil {
+		fatal(err)
+	}
+	path, err := history.DefaultPath()
+	if err != nil {
+		fatal(err)
+	}
+	sessions, err := history.Load(path)
+	if err != nil {
+		fatal(err)
+	}
+	out := historyOutput{
+		Path:     path,
+		Since:    since,
+		Game:     strings.TrimSpace(*flagGame),
+		Sessions: history.Filter(sessions, since, strings.TrimSpace(*flagGame)),
+	}
+	out.Summary = summarizeHistory(out.Sessions)
+
+	if *flagJSON {
+		b, _ := json.MarshalIndent(out, "", "  ")
+		fmt.Println(string(b))
+		return
+	}
+	printHistoryHuman(out)
+}
+
+// parseSince accepts a duration back from now, a date or an RFC3339 time.
+func parseSince(v string, now time.Time) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q (want e.g. 24h, 2006-01-02 or RFC3339)", v)
+}
+
+func summarizeHistory(sessions []history.Session) []historySummary {
+	byGame := map[string]*historySummary{}
+	for _, s := range sessions {
+		sum := byGame[s.GameID]
+		if sum == nil {
+			sum = &historySummary{GameID: s.GameID}
+			byGame[s.GameID] = sum
+		}
+		if s.Name != "" {
+			sum.Name = s.Name
+		}
+		sum.Sessions++
+		sum.Total += s.Duration()
+		if s.Placed {
+			sum.Placed++
+		}
+		if s.SlicesPinned {
+			sum.Pinned++
+		}
+		sum.Reapplies += s.Reapplies
+		sum.Errors += len(s.Errors)
+		if s.End.After(sum.LastEnd) {
+			sum.LastEnd = s.End
+		}
+	}
+	out := make([]historySummary, 0, len(byGame))
+	for _, sum := range byGame {
+		out = append(out, *sum)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].LastEnd.After(out[j].LastEnd) })
+	return out
+}
+
+func printHistoryHuman(out historyOutput) {
+	if len(out.Sessions) == 0 {
+		fmt.Printf("no sessions in %s\n", out.Path)
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "START\tDURATION\tGAME\tNAME\tMODE\tPLACED\tSLICES\tOFF_GAME_CPU\tREAPPLIES\tERRORS")
+	for _, s := range out.Sessions {
+		dur := s.Duration().Round(time.Second).String()
+		if s.Interrupted {
+			dur += "*"
+		}
+		off := "-"
+		if p := s.Placement; p != nil && p.Samples > 0 {
+			off = fmt.Sprintf("%.1f%%", 100*p.OffGameRatio())
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%d\n",
+			s.Start.Local().Format("2006-01-02 15:04"), dur, s.GameID, s.Name, s.Mode,
+			yesNo(s.Placed), yesNo(s.SlicesPinned), off, s.Reapplies, len(s.Errors))
+	}
+	tw.Flush()
+
+	fmt.Println()
+	fmt.Println("summary:")
+	for _, sum := range out.Summary {
+		name := ""
+		if sum.Name != "" {
+			name = " (" + sum.Name + ")"
+		}
+		fmt.Printf("  %s%s: sessions=%d total=%s placed=%d/%d slices_pinned=%d/%d reapplies=%d errors=%d\n",
+			sum.GameID, name, sum.Sessions, sum.Total.Round(time.Second), sum.Placed, sum.Sessions, sum.Pinned, sum.Sessions, sum.Reapplies, sum.Errors)
+	}
+	for _, s := range out.Sessions {
+		if s.Interrupted {
+			fmt.Println("  * session ended by daemon shutdown")
+			break
+		}
+	}
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Hook events; the names match the [hooks] keys and are passed to the command
+// as CCDBIND_EVENT.
+const (
+	hookGameStart  = "on_game_start"
+	hookGameStop   = "on_game_stop"
+	hookPinApplied = "on_pin_applied"
+	hookRestore    = "on_restore"
+)
+
+const hookQueueSize = 32
+
+type hookJob struct {
+	event   string
+	command string
+	timeout time.Duration
+	env     []string
+}
+
+// hookRunner runs hook commands one at a time on its own goroutine, so a
+// slow hook never stalls the tick and a stop hook cannot overtake the start
+// hook queued before it.
+type hookRunner struct {
+	queue chan hookJob
+	done  chan struct{}
+}
+
+func startHooks() *hookRunner {
+	h := &hookRunner{queue: make(chan hookJob, hookQueueSize), done: make(chan struct{})}
+	go func() {
+		defer close(h.done)
+		for job := range h.queue {
+			runHookJob(job)
+		}
+	}()
+	return h
+}
+
+// drain stops accepting hooks and waits up to timeout for queued ones.
+func (h *hookRunner) drain(timeout time.Duration) {
+	if h == nil {
+		return
+	}
+	close(h.queue)
+	select {
+	case <-h.done:
+	case <-time.After(timeout):
+		log.Printf("hooks still running after %s; not waiting", timeout)
+	}
+}
+
+// fireHook queues command for event with env appended to the daemon's
+// environment. Empty commands are ignored.
+func (r *runtime) fireHook(event, command string, env []string) {
+	if command == "" || r.hooks == nil {
+		return
+	}
+	if r.dryRun {
+		log.Printf("dry-run: hook %s: %s", event, command)
+		return
+	}
+	timeout := r.cfg.Hooks.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	job := hookJob{
+		event:   event,
+		command: command,
+		timeout: timeout,
+		env:     append(append(os.Environ(), "CCDBIND_EVENT="+event), env...),
+	}
+	select {
+	case r.hooks.queue <- job:
+	default:
+		log.Printf("hook %s dropped: queue full", event)
+	}
+}
+
+func runHookJob(job hookJob) {
+	ctx, cancel := context.WithTimeout(context.Background(), job.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", job.command)
+	cmd.Env = job.env
+	// Run in a new process group so a timeout also kills anything the
+	// command spawned.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) }
+	cmd.WaitDelay = time.Second
+
+	start := time.Now()
+	out, err := cmd.CombinedOutput()
+	dur := time.Since(start).Round(time.Millisecond)
+
+	code := -1
+	if cmd.ProcessState != nil {
+		code = cmd.ProcessState.ExitCode()
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		log.Printf("hook %s killed after %s timeout", job.event, job.timeout)
+	case err != nil && !errors.As(err, &exitErr):
+		log.Printf("hook %s: %v", job.event, err)
+	default:
+		log.Printf("hook %s exited code=%d in %s", job.event, code, dur)
+	}
+	if s := strings.TrimSpace(string(out)); s != "" {
+		if len(s) > 512 {
+			s = s[:512] + "..."
+		}
+		log.Printf("hook %s output: %s", job.event, s)
+	}
+}
+
+// gameHookEnv describes one game for on_game_start/on_game_stop.
+// CCDBIND_SCOPE is empty for games placed in affinity mode.
+func (r *runtime) gameHookEnv(g *activeGame) []string {
+	return []string{
+		"CCDBIND_GAME_ID=" + g.id,
+		"CCDBIND_GAME_NAME=" + g.name,
+		"CCDBIND_MODE=" + g.mode,
+		"CCDBIND_SCOPE=" + g.scope(),
+		"CCDBIND_OS_CPUS=" + r.osCPUs,
+		"CCDBIND_GAME_CPUS=" + r.gameCPUs,
+		"CCDBIND_PIDS=" + joinPIDs(g.pids),
+	}
+}
+
+// pinHookEnv describes the pin state for on_pin_applied/on_restore.
+func (r *runtime) pinHookEnv(slices []string) []string {
+	ids := make([]string, 0, len(r.active))
+	var pids []int
+	for id, g := range r.active {
+		ids = append(ids, id)
+		pids = append(pids, g.pids...)
+	}
+	sort.Strings(ids)
+	sort.Ints(pids)
+	return []string{
+		"CCDBIND_GAME_IDS=" + strings.Join(ids, " "),
+		"CCDBIND_SLICES=" + strings.Join(slices, " "),
+		"CCDBIND_OS_CPUS=" + r.osCPUs,
+		"CCDBIND_GAME_CPUS=" + r.gameCPUs,
+		"CCDBIND_PIDS=" + joinPIDs(pids),
+	}
+}
+
+func joinPIDs(pids []int) string {
+	parts := make([]string, len(pids))
+	for i, pid := range pids {
+		parts[i] = strconv.Itoa(pid)
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/control"
+	"github.com/Reidond/ccdbind/internal/pinstate"
+	"github.com/Reidond/ccdbind/internal/procscan"
+	"github.com/Reidond/ccdbind/internal/systemdctl"
+)
+
+// launcher is a ccdpin instance that registered with the daemon instead of
+// pinning the slices itself.
+type launcher struct {
+	gameID    string
+	startTime uint64
+	since     time.Time
+}
+
+func (r *runtime) registerLauncher(pid int, gameID string) error {
+	start, err := procscan.StartTime(pid)
+	if err != nil {
+		return fmt.Errorf("pid %d: %w", pid, err)
+	}
+	r.launchers[pid] = launcher{gameID: gameID, startTime: start, since: time.Now()}
+	log.Printf("launcher registered pid=%d game_id=%q", pid, gameID)
+	return nil
+}
+
+func (r *runtime) unregisterLauncher(pid int) {
+	if _, ok := r.launchers[pid]; ok {
+		delete(r.launchers, pid)
+		log.Printf("launcher unregistered pid=%d", pid)
+	}
+}
+
+// refreshLaunchers forgets launchers that exited without unregistering and
+// checks whether ccdpin instances started without the daemon hold the
+// slices. While they do, their state file has the slices' originals and the
+// last of them restores the slices, so the daemon must not pin them too.
+func (r *runtime) refreshLaunchers() {
+	for pid, l := range r.launchers {
+		if start, err := procscan.StartTime(pid); err != nil || start != l.startTime {
+			delete(r.launchers, pid)
+			log.Printf("launcher pid=%d gone", pid)
+		}
+	}
+
+	held := false
+	if store, err := pinstate.Default(); err == nil {
+		if ps, err := store.Read(); err == nil {
+			ps.PruneDead()
+			held = ps.Pinned()
+		}
+	}
+	if held != r.slicesHeldByCcdpin {
+		if held {
+			log.Printf("slices are pinned by ccdpin; leaving them to it")
+		} else {
+			log.Printf("ccdpin released the slices")
+		}
+		r.slicesHeldByCcdpin = held
+	}
+}
+
+// launchedGame reports whether a registered launcher is starting id.
+func (r *runtime) launchedGame(id string) bool {
+	for _, l := range r.launchers {
+		if l.gameID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *runtime) launcherStatus() []control.Launcher {
+	out := make([]control.Launcher, 0, len(r.launchers))
+	for pid, l := range r.launchers {
+		out = append(out, control.Launcher{PID: pid, GameID: l.gameID, Since: l.since})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].PID < out[j].PID })
+	return out
+}
+
+// recoverCcdpinPin restores slices that ccdpin instances left pinned when
+// they died without releasing them, e.g. on SIGKILL or logout. Live
+// instances keep their pin.
+func recoverCcdpinPin(r *runtime, be systemdctl.Backend) {
+	if r.dryRun || !r.cfg.RecoverCcdpin {
+		return
+	}
+	store, err := pinstate.Default()
+	if err != nil {
+		log.Printf("ccdpin recovery: %v", err)
+		return
+	}
+	store.Logf = log.Printf
+	rec, err := store.Recover(false, func(unit, cpus string) error {
+		ctx, cancel := systemdctl.DefaultContext()
+		defer cancel()
+		return be.SetAllowedCPUs(ctx, unit, cpus)
+	})
+	for _, unit := range rec.Restored {
+		log.Printf("ccdpin recovery: restored %s AllowedCPUs=%q left pinned by a dead ccdpin", unit, rec.Originals[unit])
+	}
+	if err != nil {
+		log.Printf("ccdpin recovery: %v", err)
+	}
+}
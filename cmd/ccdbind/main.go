@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -13,8 +14,12 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/Reidond/ccdbind/internal/cgroupfs"
 	"github.com/Reidond/ccdbind/internal/config"
+	"github.com/Reidond/ccdbind/internal/control"
+	"github.com/Reidond/ccdbind/internal/history"
 	"github.com/Reidond/ccdbind/internal/procscan"
+	"github.com/Reidond/ccdbind/internal/sdnotify"
 	"github.com/Reidond/ccdbind/internal/state"
 	"github.com/Reidond/ccdbind/internal/systemdctl"
 	"github.com/Reidond/ccdbind/internal/topology"
@@ -22,24 +27,77 @@ import (
 
 type runtime struct {
 	dryRun bool
+	cfg    config.Config
 
 	osCPUs   string
 	gameCPUs string
 
 	pidToUnit map[int]pidRecord
+
+	sliceStarted bool
+	sliceBackoff backoff
+	scopeBackoff map[string]*backoff
+
+	startedAt time.Time
+	active    map[string]*activeGame
+
+	// ov points at the loaded state's Overrides, so every state.Save
+	// persists changes made through it.
+	ov *state.Overrides
+
+	// seenAt and goneAt track games waiting out start_delay and stop_grace.
+	seenAt map[string]time.Time
+	goneAt map[string]time.Time
+
+	// launchers are ccdpin instances that left slice pinning to the daemon;
+	// slicesHeldByCcdpin is set while ccdpin instances own the pin instead.
+	launchers          map[int]launcher
+	slicesHeldByCcdpin bool
+
+	hooks       *hookRunner
+	emit        func(name string, args ...any)
+	m           *metrics
+	historyPath string
+
+	lastTick    time.Time
+	lastTickDur time.Duration
+	lastErr     string
+	lastErrAt   time.Time
 }
 
 type pidRecord struct {
+	gameID    string
 	unit      string
 	startTime uint64
+
+	// tids holds threads already pinned in affinity mode; unit is empty then.
+	tids map[int]struct{}
 }
 
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
 
-	if len(os.Args) > 1 && os.Args[1] == "status" {
-		runStatus(os.Args[2:])
-		return
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "status":
+			runStatus(os.Args[2:])
+			return
+		case "pause":
+			runPause(os.Args[2:])
+			return
+		case "resume":
+			runResume(os.Args[2:])
+			return
+		case "history":
+			runHistory(os.Args[2:])
+			return
+		case "doctor":
+			runDoctor(os.Args[2:])
+			return
+		case "pin", "unpin":
+			runPin(os.Args[2:], os.Args[1] == "pin")
+			return
+		}
 	}
 
 	runDaemon(os.Args[1:])
@@ -91,7 +149,18 @@ func runDaemon(args []string) {
 		cfg.Interval = 2 * time.Second
 	}
 
-	r := &runtime{dryRun: *flagDryRun, pidToUnit: map[int]pidRecord{}}
+	r := &runtime{
+		dryRun:       *flagDryRun,
+		cfg:          cfg,
+		pidToUnit:    map[int]pidRecord{},
+		scopeBackoff: map[string]*backoff{},
+		startedAt:    time.Now(),
+		active:       map[string]*activeGame{},
+		seenAt:       map[string]time.Time{},
+		goneAt:       map[string]time.Time{},
+		launchers:    map[int]launcher{},
+		emit:         func(string, ...any) {},
+	}
 
 	effectiveOS, effectiveGame, err := resolveCPUs(cfg)
 	if err != nil {
@@ -107,21 +176,20 @@ func runDaemon(args []string) {
 	}
 
 	uid := os.Getuid()
-	slices := slicesToPin(cfg)
+	slices := cfg.OSSlices()
 
-	sys := systemdctl.Systemctl{DryRun: r.dryRun}
-	// Best-effort: ensure game.slice exists/loads.
-	{
-		ctx2, cancel := systemdctl.DefaultContext()
-		_ = sys.StartUnit(ctx2, "game.slice")
-		cancel()
-	}
-
-	mgr, err := systemdctl.NewUserManager(r.dryRun)
+	be, err := newBackend(cfg, r.dryRun)
 	if err != nil {
-		fatal(fmt.Errorf("connect to user dbus: %w", err))
+		fatal(err)
 	}
-	defer mgr.Close()
+	r.m = newMetrics(be.Name())
+	be = instrumentedBackend{Backend: be, m: r.m}
+	defer be.Close()
+	if err := be.CheckCPUSet(); err != nil {
+		log.Printf("warning: %s backend cannot enforce AllowedCPUs: %v", be.Name(), err)
+	}
+
+	ensureGameSlice(r, be)
 
 	scanner := procscan.NewScanner(uid, cfg.EnvKeys, cfg.ExeAllowlist, cfg.IgnoreExe)
 
@@ -129,14 +197,40 @@ func runDaemon(args []string) {
 	if err != nil {
 		fatal(err)
 	}
+	r.ov = &st.Overrides
+	if r.historyPath, err = history.DefaultPath(); err != nil {
+		log.Printf("history disabled: %v", err)
+	}
+	if r.ov.IsPaused(time.Now()) {
+		log.Printf("starting paused (run `ccdbind resume` to resume)")
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	if err := restoreIfNeeded(ctx, scanner, sys, statePath, &st, slices); err != nil {
+	r.hooks = startHooks()
+	defer func() { r.hooks.drain(r.cfg.Hooks.Timeout) }()
+
+	recoverCcdpinPin(r, be)
+	if err := restoreIfNeeded(ctx, r, scanner, be, statePath, &st, slices); err != nil {
 		log.Printf("restoreIfNeeded: %v", err)
 	}
 
+	if r.ov.IsPaused(time.Now()) {
+		// Leave scopes alone while paused; they are reconciled by later ticks.
+	} else if games, err := scanner.Scan(); err != nil {
+		log.Printf("scan: %v", err)
+	} else {
+		report := adoptScopes(ctx, r, be, games)
+		for _, e := range report.Errors {
+			log.Printf("adopt scopes: %s", e)
+		}
+		st.LastScopeGC = &report
+		if err := state.Save(statePath, st); err != nil {
+			log.Printf("save state: %v", err)
+		}
+	}
+
 	sigc := make(chan os.Signal, 2)
 	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
@@ -148,43 +242,243 @@ func runDaemon(args []string) {
 	ticker := time.NewTicker(cfg.Interval)
 	defer ticker.Stop()
 
-	log.Printf("ccdbind started interval=%s os_cpus=%q game_cpus=%q dry_run=%v", cfg.Interval, r.osCPUs, r.gameCPUs, r.dryRun)
+	ctlReqs := make(chan func())
+	ctl := &controlServer{
+		reqs:   ctlReqs,
+		status: func() control.Status { return r.snapshot(be, st) },
+		pause: func(until time.Time) error {
+			r.ov.Pause(until)
+			if until.IsZero() {
+				log.Printf("paused until resumed")
+			} else {
+				log.Printf("paused until %s", until.Format(time.RFC3339))
+			}
+			if st.PinApplied {
+				return restorePinned(r, be, statePath, &st, slices)
+			}
+			return state.Save(statePath, st)
+		},
+		resume: func() error {
+			r.ov.Resume()
+			log.Printf("resumed")
+			return state.Save(statePath, st)
+		},
+		forceRestore: func() error {
+			log.Printf("forced restore requested")
+			return restorePinned(r, be, statePath, &st, slices)
+		},
+		pinPID: func(pid int) error {
+			if err := pinPID(r.ov, pid); err != nil {
+				return err
+			}
+			log.Printf("manual pin pid=%d", pid)
+			return state.Save(statePath, st)
+		},
+		unpinPID: func(pid int) error {
+			if err := unpinPID(r.ov, pid); err != nil {
+				return err
+			}
+			log.Printf("manual unpin pid=%d", pid)
+			return state.Save(statePath, st)
+		},
+		pinGame: func(id string) error {
+			r.ov.PinGame(id)
+			log.Printf("manual pin game=%s", id)
+			return state.Save(statePath, st)
+		},
+		unpinGame: func(id string) error {
+			r.ov.UnpinGame(id)
+			log.Printf("manual unpin game=%s", id)
+			return state.Save(statePath, st)
+		},
+		launcher: func(pid int, gameID string) error {
+			return r.registerLauncher(pid, gameID)
+		},
+		unlauncher: r.unregisterLauncher,
+		reload: func() error {
+			newCfg, err := config.Load(configPath)
+			if err != nil {
+				return err
+			}
+			if *flagInterval > 0 {
+				newCfg.Interval = *flagInterval
+			}
+			if newCfg.Interval <= 0 {
+				newCfg.Interval = 2 * time.Second
+			}
+			osCPUs, gameCPUs, err := resolveCPUs(newCfg)
+			if err != nil {
+				return err
+			}
+			if newCfg.Backend != cfg.Backend || newCfg.CgroupRoot != cfg.CgroupRoot {
+				log.Printf("reload: backend changes take effect after a restart")
+			}
+			if newCfg.MetricsListen != cfg.MetricsListen {
+				log.Printf("reload: metrics_listen changes take effect after a restart")
+			}
+			newSlices := newCfg.OSSlices()
+			if st.PinApplied {
+				if err := restoreSlices(be, subtract(slices, newSlices), st.OriginalAllowedCPUs); err != nil {
+					return err
+				}
+			}
+			cfg, r.cfg = newCfg, newCfg
+			r.osCPUs, r.gameCPUs = osCPUs, gameCPUs
+			slices = newSlices
+			scanner = procscan.NewScanner(uid, cfg.EnvKeys, cfg.ExeAllowlist, cfg.IgnoreExe)
+			ticker.Reset(cfg.Interval)
+			log.Printf("config reloaded interval=%s os_cpus=%q game_cpus=%q slices=%v", cfg.Interval, r.osCPUs, r.gameCPUs, slices)
+			return nil
+		},
+	}
+	if err := startControl(ctl); err != nil {
+		log.Printf("control API disabled: %v", err)
+	} else {
+		defer ctl.Close()
+		r.emit = ctl.emit
+	}
+
+	if cfg.MetricsListen != "" {
+		srv, err := serveMetrics(cfg.MetricsListen, r.m)
+		if err != nil {
+			log.Printf("metrics disabled: %v", err)
+		} else {
+			defer shutdownMetrics(srv)
+			log.Printf("serving metrics on %s", cfg.MetricsListen)
+		}
+	}
+
+	// A nil channel never fires, which disables the watchdog case below.
+	var watchdog <-chan time.Time
+	if d, err := sdnotify.WatchdogInterval(); err != nil {
+		log.Printf("watchdog: %v", err)
+	} else if d > 0 {
+		wt := time.NewTicker(d)
+		defer wt.Stop()
+		watchdog = wt.C
+	}
+
+	log.Printf("ccdbind started interval=%s backend=%s os_cpus=%q game_cpus=%q dry_run=%v", cfg.Interval, be.Name(), r.osCPUs, r.gameCPUs, r.dryRun)
+	lastStatus := r.statusLine(st, time.Now())
+	notify(sdnotify.Ready + "\n" + sdnotify.Status(lastStatus))
+	updateStatus := func(now time.Time) {
+		if line := r.statusLine(st, now); line != lastStatus {
+			lastStatus = line
+			notify(sdnotify.Status(line))
+		}
+	}
 	for {
 		select {
 		case <-ctx.Done():
+			notify(sdnotify.Stopping)
+			r.closeSessions(time.Now())
 			if st.PinApplied {
-				if err := restoreSlices(sys, slices, st.OriginalAllowedCPUs); err != nil {
+				if err := restorePinned(r, be, statePath, &st, slices); err != nil {
 					log.Printf("restore on exit: %v", err)
-				} else {
-					st.PinApplied = false
-					st.LastSuccessfulRestore = time.Now()
-					_ = state.Save(statePath, st)
 				}
 			}
 			return
+		case fn := <-ctlReqs:
+			fn()
+			updateStatus(time.Now())
+		case <-watchdog:
+			// Sent from this loop so that a hung tick stops the pings and
+			// systemd restarts the daemon.
+			notify(sdnotify.Watchdog)
 		case <-ticker.C:
+			start := time.Now()
+			if r.ov.Paused && !r.ov.IsPaused(start) {
+				log.Printf("pause expired; resuming")
+				r.ov.Resume()
+				if err := state.Save(statePath, st); err != nil {
+					log.Printf("save state: %v", err)
+				}
+			}
+			if r.ov.IsPaused(start) {
+				r.m.observeTick(nil, len(r.active), st.PinApplied, true, nil)
+				updateStatus(start)
+				continue
+			}
 			games, err := scanner.Scan()
+			r.m.observeScan(time.Since(start), scanner.Scanned)
 			if err != nil {
 				log.Printf("scan: %v", err)
+				r.recordTick(start, err)
+				r.m.observeTick(err, len(r.active), st.PinApplied, false, nil)
 				continue
 			}
-			if err := handleTick(ctx, r, sys, mgr, statePath, &st, slices, games); err != nil {
+			games, changed := r.applyOverrides(games)
+			if changed {
+				if err := state.Save(statePath, st); err != nil {
+					log.Printf("save state: %v", err)
+				}
+			}
+			r.refreshLaunchers()
+			games = r.settle(games, start)
+			err = handleTick(ctx, r, be, statePath, &st, slices, games)
+			if err != nil {
 				log.Printf("tick: %v", err)
 			}
+			r.recordTick(start, err)
+			scopes := r.scopeCgroups(games)
+			r.m.observeTick(err, len(r.active), st.PinApplied, false, scopes)
+			r.samplePlacement(games, scopes)
+			updateStatus(start)
 		}
 	}
 }
 
-func slicesToPin(cfg config.Config) []string {
-	slices := append([]string{}, cfg.PinSlices...)
-	if cfg.PinSessionSlice {
-		slices = append(slices, "session.slice")
+// statusLine summarises the daemon state for systemd's STATUS=.
+func (r *runtime) statusLine(st state.File, now time.Time) string {
+	if r.ov.IsPaused(now) {
+		return "paused"
 	}
-	slices = dedupe(slices)
-	if len(slices) == 0 {
-		return []string{"app.slice", "background.slice"}
+	pin := "slices not pinned"
+	switch {
+	case st.PinApplied:
+		pin = "slices pinned to " + r.osCPUs
+	case r.slicesHeldByCcdpin:
+		pin = "slices pinned by ccdpin"
+	}
+	return fmt.Sprintf("%d game(s) active; %s", len(r.active), pin)
+}
+
+func notify(state string) {
+	if _, err := sdnotify.Notify(state); err != nil {
+		log.Printf("sd_notify: %v", err)
+	}
+}
+
+// subtract returns the entries of a that are not in b.
+func subtract(a, b []string) []string {
+	drop := make(map[string]struct{}, len(b))
+	for _, s := range b {
+		drop[s] = struct{}{}
+	}
+	var out []string
+	for _, s := range a {
+		if _, ok := drop[s]; !ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func newBackend(cfg config.Config, dryRun bool) (systemdctl.Backend, error) {
+	switch cfg.Backend {
+	case "cgroupfs":
+		be, err := cgroupfs.New(cfg.CgroupRoot, dryRun)
+		if err != nil {
+			return nil, fmt.Errorf("cgroupfs backend: %w", err)
+		}
+		return be, nil
+	default:
+		be, err := systemdctl.NewSystemdBackend(dryRun)
+		if err != nil {
+			return nil, fmt.Errorf("connect to user dbus: %w", err)
+		}
+		return be, nil
 	}
-	return slices
 }
 
 func resolveCPUs(cfg config.Config) (string, string, error) {
@@ -210,7 +504,7 @@ func resolveCPUs(cfg config.Config) (string, string, error) {
 	return res.OSCPUs, res.GameCPUs, nil
 }
 
-func restoreIfNeeded(ctx context.Context, scanner *procscan.Scanner, sys systemdctl.Systemctl, statePath string, st *state.File, slices []string) error {
+func restoreIfNeeded(ctx context.Context, r *runtime, scanner *procscan.Scanner, be systemdctl.Backend, statePath string, st *state.File, slices []string) error {
 	if !st.PinApplied {
 		return nil
 	}
@@ -221,24 +515,62 @@ func restoreIfNeeded(ctx context.Context, scanner *procscan.Scanner, sys systemd
 	if len(games) > 0 {
 		return nil
 	}
-	if err := restoreSlices(sys, slices, st.OriginalAllowedCPUs); err != nil {
+	return restorePinned(r, be, statePath, st, slices)
+}
+
+// restorePinned puts the slices back to their original AllowedCPUs, records
+// the restore in the state file and fires the on_restore hook.
+func restorePinned(r *runtime, be systemdctl.Backend, statePath string, st *state.File, slices []string) error {
+	if err := restoreSlices(be, slices, st.OriginalAllowedCPUs); err != nil {
 		return err
 	}
 	st.PinApplied = false
 	st.LastSuccessfulRestore = time.Now()
+	r.fireHook(hookRestore, r.cfg.Hooks.OnRestore, r.pinHookEnv(slices))
 	return state.Save(statePath, *st)
 }
 
-func handleTick(ctx context.Context, r *runtime, sys systemdctl.Systemctl, mgr *systemdctl.UserManager, statePath string, st *state.File, slices []string, games map[string][]procscan.GameProcess) error {
-	if len(games) == 0 {
+// ensureGameSlice starts game.slice, retrying with backoff until it succeeds.
+// Scopes are created under it, so a failure here usually explains later
+// scope failures too.
+func ensureGameSlice(r *runtime, be systemdctl.Backend) {
+	now := time.Now()
+	if r.sliceStarted || !r.sliceBackoff.ready(now) {
+		return
+	}
+	ctx2, cancel := systemdctl.DefaultContext()
+	err := be.StartUnit(ctx2, "game.slice")
+	cancel()
+	if err != nil {
+		delay := r.sliceBackoff.fail(now)
+		log.Printf("start game.slice: %v (is game.slice installed in ~/.config/systemd/user?); retrying in %s", err, delay)
+		return
+	}
+	r.sliceStarted = true
+	r.sliceBackoff.reset()
+}
+
+func handleTick(ctx context.Context, r *runtime, be systemdctl.Backend, statePath string, st *state.File, slices []string, games map[string][]procscan.GameProcess) error {
+	started, stopped := r.trackGames(games, time.Now())
+	for _, g := range stopped {
+		log.Printf("game stopped id=%s", g.id)
+		r.emit(control.SignalGameStopped, g.id, g.unit)
+		r.fireHook(hookGameStop, r.cfg.Hooks.OnGameStop, r.gameHookEnv(g))
+		r.recordSession(g, time.Now(), false)
+	}
+	// Start hooks run once placement has been attempted, so the scope exists.
+	defer func() {
+		for _, g := range started {
+			log.Printf("game started id=%s name=%q", g.id, g.name)
+			r.emit(control.SignalGameStarted, g.id, g.unit)
+			r.fireHook(hookGameStart, r.cfg.Hooks.OnGameStart, r.gameHookEnv(g))
+		}
+	}()
+
+	if len(games) == 0 && len(r.launchers) == 0 {
 		if st.PinApplied {
 			log.Printf("no games active; restoring slices")
-			if err := restoreSlices(sys, slices, st.OriginalAllowedCPUs); err != nil {
-				return err
-			}
-			st.PinApplied = false
-			st.LastSuccessfulRestore = time.Now()
-			if err := state.Save(statePath, *st); err != nil {
+			if err := restorePinned(r, be, statePath, st, slices); err != nil {
 				return err
 			}
 			r.pidToUnit = map[int]pidRecord{}
@@ -246,14 +578,165 @@ func handleTick(ctx context.Context, r *runtime, sys systemdctl.Systemctl, mgr *
 		return nil
 	}
 
-	currentAllowed, err := readAllowedCPUs(sys, slices)
+	// ccdpin instances started without the daemon own the pin and its
+	// originals; pinning here too would snapshot their pinned values.
+	if st.PinApplied || !r.slicesHeldByCcdpin {
+		if err := applySlicePin(r, be, statePath, st, slices, games); err != nil {
+			return err
+		}
+	}
+
+	for _, g := range r.active {
+		g.slicesPinned = true
+	}
+
+	ensureGameSlice(r, be)
+
+	var errs []error
+	now := time.Now()
+	alive := make(map[int]struct{}, 32)
+	activeUnits := make(map[string]struct{}, len(games))
+	gameIDs := make([]string, 0, len(games))
+	for gameID := range games {
+		gameIDs = append(gameIDs, gameID)
+	}
+	sort.Strings(gameIDs)
+
+	for _, gameID := range gameIDs {
+		procs := games[gameID]
+		unit := systemdctl.UnitNameForGameID(gameID)
+		if len(procs) == 0 {
+			continue
+		}
+		activeUnits[unit] = struct{}{}
+
+		if r.cfg.ModeFor(gameID) == config.ModeAffinity {
+			for _, gp := range procs {
+				alive[gp.PID] = struct{}{}
+			}
+			if err := applyAffinity(r, gameID, procs); err != nil {
+				errs = append(errs, err)
+				r.sessionError(gameID, err)
+			} else {
+				r.markPlaced(gameID)
+			}
+			continue
+		}
+
+		pids := make([]int, 0, len(procs))
+		newPIDs := make([]int, 0, len(procs))
+		pidStarts := make(map[int]uint64, len(procs))
+		for _, gp := range procs {
+			alive[gp.PID] = struct{}{}
+			pidStarts[gp.PID] = gp.StartTime
+
+			pids = append(pids, gp.PID)
+
+			rec, ok := r.pidToUnit[gp.PID]
+			if !ok || rec.unit != unit {
+				newPIDs = append(newPIDs, gp.PID)
+				continue
+			}
+			if rec.startTime == 0 || gp.StartTime == 0 {
+				newPIDs = append(newPIDs, gp.PID)
+				continue
+			}
+			if rec.startTime != gp.StartTime {
+				newPIDs = append(newPIDs, gp.PID)
+				continue
+			}
+			// Steam or gamescope may have moved the process since we attached it.
+			if gp.Cgroup != "" && !procscan.CgroupInUnit(gp.Cgroup, unit) {
+				log.Printf("pid %d left %s (cgroup=%s); reattaching", gp.PID, unit, gp.Cgroup)
+				newPIDs = append(newPIDs, gp.PID)
+			}
+		}
+
+		bo := r.scopeBackoff[unit]
+		if bo == nil {
+			bo = &backoff{}
+			r.scopeBackoff[unit] = bo
+		}
+		if !bo.ready(now) {
+			continue
+		}
+		retry := func(err error) {
+			delay := bo.fail(now)
+			errs = append(errs, fmt.Errorf("%w; retrying in %s", err, delay))
+			r.sessionError(gameID, err)
+		}
+
+		desc := fmt.Sprintf("ccdbind game %s", gameID)
+		ctx2, cancel := context.WithTimeout(ctx, 10*time.Second)
+		created, err := be.EnsureTransientScope(ctx2, unit, pids, "game.slice", desc)
+		cancel()
+		if err != nil {
+			retry(fmt.Errorf("EnsureTransientScope %s: %w", unit, err))
+			continue
+		}
+
+		ctx2, cancel = systemdctl.DefaultContext()
+		err = be.SetAllowedCPUs(ctx2, unit, r.gameCPUs)
+		cancel()
+		if err != nil {
+			retry(fmt.Errorf("pin scope %s: %w", unit, err))
+			continue
+		}
+
+		if created {
+			for _, pid := range pids {
+				r.pidToUnit[pid] = pidRecord{gameID: gameID, unit: unit, startTime: pidStarts[pid]}
+			}
+		} else if len(newPIDs) > 0 {
+			ctx2, cancel = context.WithTimeout(ctx, 5*time.Second)
+			err = be.AttachProcessesToUnit(ctx2, unit, "", newPIDs)
+			cancel()
+			if err != nil {
+				retry(fmt.Errorf("AttachProcessesToUnit %s: %w", unit, err))
+				continue
+			}
+			for _, pid := range newPIDs {
+				r.pidToUnit[pid] = pidRecord{gameID: gameID, unit: unit, startTime: pidStarts[pid]}
+			}
+		}
+		bo.reset()
+		r.markPlaced(gameID)
+	}
+
+	for pid := range r.pidToUnit {
+		if _, ok := alive[pid]; !ok {
+			delete(r.pidToUnit, pid)
+		}
+	}
+	for unit := range r.scopeBackoff {
+		if _, ok := activeUnits[unit]; !ok {
+			delete(r.scopeBackoff, unit)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// applySlicePin pins the configured slices to the OS CPUs, snapshotting their
+// originals on the first pin and reapplying the pin when a slice drifted.
+// Slices hosting an affinity-mode game are left unpinned.
+func applySlicePin(r *runtime, be systemdctl.Backend, statePath string, st *state.File, slices []string, games map[string][]procscan.GameProcess) error {
+	currentAllowed, err := readAllowedCPUs(be, slices)
 	if err != nil {
 		return err
 	}
 
+	hosting := affinityHostSlices(r, slices, games)
+	pinSlices := make([]string, 0, len(slices))
+	for _, unit := range slices {
+		if _, ok := hosting[unit]; !ok {
+			pinSlices = append(pinSlices, unit)
+		}
+	}
+
 	reapplyNeeded := !st.PinApplied
 	if st.PinApplied {
-		for _, unit := range slices {
+		for _, unit := range pinSlices {
 			if currentAllowed[unit] != r.osCPUs {
 				reapplyNeeded = true
 				break
@@ -300,109 +783,54 @@ func handleTick(ctx context.Context, r *runtime, sys systemdctl.Systemctl, mgr *
 		msg := "games active; pinning"
 		if st.PinApplied {
 			msg = "games active; reapplying pin"
+			r.m.reapplied()
+			for _, g := range r.active {
+				g.reapplies++
+			}
 		}
-		log.Printf("%s slices=%v to os_cpus=%q", msg, slices, r.osCPUs)
-		for _, unit := range slices {
+		log.Printf("%s slices=%v to os_cpus=%q", msg, pinSlices, r.osCPUs)
+		for _, unit := range pinSlices {
 			ctx2, cancel := systemdctl.DefaultContext()
-			err := sys.SetAllowedCPUs(ctx2, unit, r.osCPUs)
+			err := be.SetAllowedCPUs(ctx2, unit, r.osCPUs)
 			cancel()
 			if err != nil {
 				return err
 			}
 		}
+		firstPin := !st.PinApplied
 		st.PinApplied = true
 		st.OriginalAllowedCPUs = orig
 		st.OSCPUs = r.osCPUs
 		st.GameCPUs = r.gameCPUs
 		st.LastSuccessfulPinApply = time.Now()
+		if firstPin {
+			r.fireHook(hookPinApplied, r.cfg.Hooks.OnPinApplied, r.pinHookEnv(pinSlices))
+		}
 		if err := state.Save(statePath, *st); err != nil {
 			return err
 		}
 	}
 
-	alive := make(map[int]struct{}, 32)
-	gameIDs := make([]string, 0, len(games))
-	for gameID := range games {
-		gameIDs = append(gameIDs, gameID)
-	}
-	sort.Strings(gameIDs)
-
-	for _, gameID := range gameIDs {
-		procs := games[gameID]
-		unit := systemdctl.UnitNameForGameID(gameID)
-		if len(procs) == 0 {
+	for _, unit := range slices {
+		if _, ok := hosting[unit]; !ok || currentAllowed[unit] != r.osCPUs {
 			continue
 		}
-
-		pids := make([]int, 0, len(procs))
-		newPIDs := make([]int, 0, len(procs))
-		pidStarts := make(map[int]uint64, len(procs))
-		for _, gp := range procs {
-			alive[gp.PID] = struct{}{}
-			pidStarts[gp.PID] = gp.StartTime
-
-			pids = append(pids, gp.PID)
-
-			rec, ok := r.pidToUnit[gp.PID]
-			if !ok || rec.unit != unit {
-				newPIDs = append(newPIDs, gp.PID)
-				continue
-			}
-			if rec.startTime == 0 || gp.StartTime == 0 {
-				newPIDs = append(newPIDs, gp.PID)
-				continue
-			}
-			if rec.startTime != gp.StartTime {
-				newPIDs = append(newPIDs, gp.PID)
-			}
-		}
-
-		desc := fmt.Sprintf("ccdbind game %s", gameID)
-		ctx2, cancel := context.WithTimeout(ctx, 10*time.Second)
-		created, err := mgr.EnsureTransientScope(ctx2, unit, pids, "game.slice", desc)
-		cancel()
-		if err != nil {
-			return fmt.Errorf("EnsureTransientScope %s: %w", unit, err)
-		}
-
-		ctx2, cancel = systemdctl.DefaultContext()
-		err = sys.SetAllowedCPUs(ctx2, unit, r.gameCPUs)
+		log.Printf("releasing %s: it contains an affinity-mode game", unit)
+		ctx2, cancel := systemdctl.DefaultContext()
+		err := be.SetAllowedCPUs(ctx2, unit, st.OriginalAllowedCPUs[unit])
 		cancel()
 		if err != nil {
-			return fmt.Errorf("pin scope %s: %w", unit, err)
-		}
-
-		if created {
-			for _, pid := range pids {
-				r.pidToUnit[pid] = pidRecord{unit: unit, startTime: pidStarts[pid]}
-			}
-		} else if len(newPIDs) > 0 {
-			ctx2, cancel = context.WithTimeout(ctx, 5*time.Second)
-			err = mgr.AttachProcessesToUnit(ctx2, unit, "", newPIDs)
-			cancel()
-			if err != nil {
-				return fmt.Errorf("AttachProcessesToUnit %s: %w", unit, err)
-			}
-			for _, pid := range newPIDs {
-				r.pidToUnit[pid] = pidRecord{unit: unit, startTime: pidStarts[pid]}
-			}
-		}
-	}
-
-	for pid := range r.pidToUnit {
-		if _, ok := alive[pid]; !ok {
-			delete(r.pidToUnit, pid)
+			return err
 		}
 	}
-
 	return nil
 }
 
-func readAllowedCPUs(sys systemdctl.Systemctl, slices []string) (map[string]string, error) {
+func readAllowedCPUs(be systemdctl.Backend, slices []string) (map[string]string, error) {
 	out := make(map[string]string, len(slices))
 	for _, unit := range slices {
 		ctx2, cancel := systemdctl.DefaultContext()
-		val, err := sys.GetAllowedCPUs(ctx2, unit)
+		val, err := be.GetAllowedCPUs(ctx2, unit)
 		cancel()
 		if err != nil {
 			return nil, err
@@ -412,11 +840,11 @@ func readAllowedCPUs(sys systemdctl.Systemctl, slices []string) (map[string]stri
 	return out, nil
 }
 
-func restoreSlices(sys systemdctl.Systemctl, slices []string, originals map[string]string) error {
+func restoreSlices(be systemdctl.Backend, slices []string, originals map[string]string) error {
 	for _, unit := range slices {
 		val := originals[unit]
 		ctx2, cancel := systemdctl.DefaultContext()
-		err := sys.SetAllowedCPUs(ctx2, unit, val)
+		err := be.SetAllowedCPUs(ctx2, unit, val)
 		cancel()
 		if err != nil {
 			return err
@@ -425,23 +853,6 @@ func restoreSlices(sys systemdctl.Systemctl, slices []string, originals map[stri
 	return nil
 }
 
-func dedupe(in []string) []string {
-	seen := map[string]struct{}{}
-	out := make([]string, 0, len(in))
-	for _, s := range in {
-		s = strings.TrimSpace(s)
-		if s == "" {
-			continue
-		}
-		if _, ok := seen[s]; ok {
-			continue
-		}
-		seen[s] = struct{}{}
-		out = append(out, s)
-	}
-	return out
-}
-
 func fatal(err error) {
 	log.Printf("fatal: %v", err)
 	os.Exit(1)
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/config"
+	"github.com/Reidond/ccdbind/internal/procscan"
+	"github.com/Reidond/ccdbind/internal/systemdctl"
+)
+
+// metrics holds the counters served on metrics_listen. The main loop
+// updates them; the HTTP handler reads them, hence the mutex.
+type metrics struct {
+	mu sync.Mutex
+
+	backend     string
+	activeGames int
+	pinApplied  bool
+	paused      bool
+
+	ticks       uint64
+	tickErrors  uint64
+	scans       uint64
+	scanSeconds float64
+	procEntries int
+	procTotal   uint64
+	reapplies   uint64
+
+	calls  map[string]*callStats
+	scopes []scopeCgroup
+}
+
+type callStats struct {
+	count   uint64
+	errors  uint64
+	seconds float64
+}
+
+// scopeCgroup locates a game scope's cgroup for reading cpu.stat.
+type scopeCgroup struct {
+	unit   string
+	gameID string
+	dir    string
+}
+
+func newMetrics(backend string) *metrics {
+	return &metrics{backend: backend, calls: map[string]*callStats{}}
+}
+
+func (m *metrics) observeCall(method string, start time.Time, err *error) {
+	d := time.Since(start).Seconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := m.calls[method]
+	if c == nil {
+		c = &callStats{}
+		m.calls[method] = c
+	}
+	c.count++
+	c.seconds += d
+	if *err != nil {
+		c.errors++
+	}
+}
+
+func (m *metrics) observeScan(d time.Duration, entries int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.scans++
+	m.scanSeconds += d.Seconds()
+	m.procEntries = entries
+	m.procTotal += uint64(entries)
+}
+
+func (m *metrics) observeTick(err error, activeGames int, pinApplied, paused bool, scopes []scopeCgroup) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ticks++
+	if err != nil {
+		m.tickErrors++
+	}
+	m.activeGames = activeGames
+	m.pinApplied = pinApplied
+	m.paused = paused
+	m.scopes = scopes
+}
+
+// reapplied counts a pin reapplied because a slice drifted from OS CPUs.
+func (m *metrics) reapplied() {
+	m.mu.Lock()
+	m.reapplies++
+	m.mu.Unlock()
+}
+
+// scopeCgroups returns the cgroup directories of the scope-mode games in
+// games, taken from the cgroup paths seen by the scan.
+func (r *runtime) scopeCgroups(games map[string][]procscan.GameProcess) []scopeCgroup {
+	var out []scopeCgroup
+	for id, procs := range games {
+		if r.cfg.ModeFor(id) != config.ModeScope {
+			continue
+		}
+		unit := systemdctl.UnitNameForGameID(id)
+		for _, gp := range procs {
+			i := strings.Index(gp.Cgroup+"/", "/"+unit+"/")
+			if i < 0 {
+				continue
+			}
+			dir := filepath.Join("/sys/fs/cgroup", gp.Cgroup[:i+1+len(unit)])
+			out = append(out, scopeCgroup{unit: unit, gameID: id, dir: dir})
+			break
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].unit < out[j].unit })
+	return out
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.writeTo(w)
+}
+
+func (m *metrics) writeTo(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	header := func(name, typ, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+	header("ccdbind_active_games", "gauge", "Games currently tracked by the daemon.")
+	fmt.Fprintf(w, "ccdbind_active_games %d\n", m.activeGames)
+	header("ccdbind_pin_applied", "gauge", "1 while the configured slices are pinned to OS CPUs.")
+	fmt.Fprintf(w, "ccdbind_pin_applied %d\n", boolGauge(m.pinApplied))
+	header("ccdbind_paused", "gauge", "1 while the daemon is paused.")
+	fmt.Fprintf(w, "ccdbind_paused %d\n", boolGauge(m.paused))
+
+	header("ccdbind_ticks_total", "counter", "Poll ticks handled.")
+	fmt.Fprintf(w, "ccdbind_ticks_total %d\n", m.ticks)
+	header("ccdbind_tick_errors_total", "counter", "Poll ticks that ended with an error.")
+	fmt.Fprintf(w, "ccdbind_tick_errors_total %d\n", m.tickErrors)
+	header("ccdbind_pin_reapplies_total", "counter", "Pins reapplied because a slice drifted from OS CPUs.")
+	fmt.Fprintf(w, "ccdbind_pin_reapplies_total %d\n", m.reapplies)
+
+	header("ccdbind_scan_duration_seconds", "summary", "Time spent scanning /proc.")
+	fmt.Fprintf(w, "ccdbind_scan_duration_seconds_sum %g\nccdbind_scan_duration_seconds_count %d\n", m.scanSeconds, m.scans)
+	header("ccdbind_proc_entries_scanned", "gauge", "/proc PID entries examined by the last scan.")
+	fmt.Fprintf(w, "ccdbind_proc_entries_scanned %d\n", m.procEntries)
+	header("ccdbind_proc_entries_scanned_total", "counter", "/proc PID entries examined by all scans.")
+	fmt.Fprintf(w, "ccdbind_proc_entries_scanned_total %d\n", m.procTotal)
+
+	methods := make([]string, 0, len(m.calls))
+	for method := range m.calls {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	header("ccdbind_backend_calls_total", "counter", "Calls into the cgroup backend (systemd D-Bus or cgroupfs).")
+	for _, method := range methods {
+		fmt.Fprintf(w, "ccdbind_backend_calls_total{backend=%s,method=%s} %d\n", label(m.backend), label(method), m.calls[method].count)
+	}
+	header("ccdbind_backend_call_errors_total", "counter", "Backend calls that returned an error.")
+	for _, method := range methods {
+		fmt.Fprintf(w, "ccdbind_backend_call_errors_total{backend=%s,method=%s} %d\n", label(m.backend), label(method), m.calls[method].errors)
+	}
+	header("ccdbind_backend_call_duration_seconds", "summary", "Latency of backend calls.")
+	for _, method := range methods {
+		c := m.calls[method]
+		fmt.Fprintf(w, "ccdbind_backend_call_duration_seconds_sum{backend=%s,method=%s} %g\n", label(m.backend), label(method), c.seconds)
+		fmt.Fprintf(w, "ccdbind_backend_call_duration_seconds_count{backend=%s,method=%s} %d\n", label(m.backend), label(method), c.count)
+	}
+
+	header("ccdbind_scope_cpu_usage_seconds_total", "counter", "CPU time used by each game scope, from cpu.stat.")
+	for _, sc := range m.scopes {
+		usec, err := cpuUsageUsec(filepath.Join(sc.dir, "cpu.stat"))
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(w, "ccdbind_scope_cpu_usage_seconds_total{unit=%s,game_id=%s} %g\n", label(sc.unit), label(sc.gameID), float64(usec)/1e6)
+	}
+}
+
+func boolGauge(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func label(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
+
+// cpuUsageUsec reads usage_usec from a cgroup v2 cpu.stat file.
+func cpuUsageUsec(path string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if rest, ok := strings.CutPrefix(sc.Text(), "usage_usec "); ok {
+			return strconv.ParseUint(strings.TrimSpace(rest), 10, 64)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return 0, err
+	}
+	return 0, errors.New("no usage_usec in " + path)
+}
+
+// serveMetrics serves m on addr, a host:port or unix:<path>.
+func serveMetrics(addr string, m *metrics) (*http.Server, error) {
+	network := "tcp"
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		network, addr = "unix", path
+		// Remove a socket left behind by a previous run.
+		if fi, err := os.Lstat(addr); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			_ = os.Remove(addr)
+		}
+	}
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("metrics: %v", err)
+		}
+	}()
+	return srv, nil
+}
+
+func shutdownMetrics(srv *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_ = srv.Shutdown(ctx)
+}
+
+// instrumentedBackend records latency and errors of every backend call.
+type instrumentedBackend struct {
+	systemdctl.Backend
+	m *metrics
+}
+
+func (b instrumentedBackend) GetAllowedCPUs(ctx context.Context, unit string) (cpus string, err error) {
+	defer b.m.observeCall("GetAllowedCPUs", time.Now(), &err)
+	return b.Backend.GetAllowedCPUs(ctx, unit)
+}
+
+func (b instrumentedBackend) SetAllowedCPUs(ctx context.Context, unit string, cpus string) (err error) {
+	defer b.m.observeCall("SetAllowedCPUs", time.Now(), &err)
+	return b.Backend.SetAllowedCPUs(ctx, unit, cpus)
+}
+
+func (b instrumentedBackend) StartUnit(ctx context.Context, unit string) (err error) {
+	defer b.m.observeCall("StartUnit", time.Now(), &err)
+	return b.Backend.StartUnit(ctx, unit)
+}
+
+func (b instrumentedBackend) StopUnit(ctx context.Context, unit string) (err error) {
+	defer b.m.observeCall("StopUnit", time.Now(), &err)
+	return b.Backend.StopUnit(ctx, unit)
+}
+
+func (b instrumentedBackend) EnsureTransientScope(ctx context.Context, scopeName string, pids []int, slice string, description string) (created bool, err error) {
+	defer b.m.observeCall("EnsureTransientScope", time.Now(), &err)
+	return b.Backend.EnsureTransientScope(ctx, scopeName, pids, slice, description)
+}
+
+func (b instrumentedBackend) AttachProcessesToUnit(ctx context.Context, unit string, subcgroup string, pids []int) (err error) {
+	defer b.m.observeCall("AttachProcessesToUnit", time.Now(), &err)
+	return b.Backend.AttachProcessesToUnit(ctx, unit, subcgroup, pids)
+}
+
+func (b instrumentedBackend) ListUnitsByPatterns(ctx context.Context, states []string, patterns []string) (units []systemdctl.UnitStatus, err error) {
+	defer b.m.observeCall("ListUnitsByPatterns", time.Now(), &err)
+	return b.Backend.ListUnitsByPatterns(ctx, states, patterns)
+}
+
+func (b instrumentedBackend) GetUnitProcesses(ctx context.Context, unit string) (procs []systemdctl.UnitProcess, err error) {
+	defer b.m.observeCall("GetUnitProcesses", time.Now(), &err)
+	return b.Backend.GetUnitProcesses(ctx, unit)
+}
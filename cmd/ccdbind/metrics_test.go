@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files")
+
+// TestMetricsGolden compares the exposition output with
+// testdata/metrics.golden. Run with -update to rewrite it.
+func TestMetricsGolden(t *testing.T) {
+	dir := t.TempDir()
+	scope := filepath.Join(dir, "game-570.scope")
+	if err := os.MkdirAll(scope, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	stat := "usage_usec 12500000\nuser_usec 10000000\nsystem_usec 2500000\n"
+	if err := os.WriteFile(filepath.Join(scope, "cpu.stat"), []byte(stat), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newMetrics("systemd")
+	m.activeGames = 1
+	m.pinApplied = true
+	m.ticks, m.tickErrors, m.reapplies = 120, 2, 1
+	m.scans, m.scanSeconds = 120, 0.75
+	m.procEntries, m.procTotal = 350, 42000
+	m.calls["SetAllowedCPUs"] = &callStats{count: 4, errors: 1, seconds: 0.02}
+	m.calls["GetAllowedCPUs"] = &callStats{count: 10, seconds: 0.005}
+	m.calls["odd \"method\"\\\n"] = &callStats{count: 1, seconds: 0.5}
+	m.scopes = []scopeCgroup{
+		{unit: "game-570.scope", gameID: `570 "beta"`, dir: scope},
+		// Scopes whose cpu.stat cannot be read are left out.
+		{unit: "game-730.scope", gameID: "730", dir: filepath.Join(dir, "gone.scope")},
+	}
+
+	var buf bytes.Buffer
+	m.writeTo(&buf)
+	checkGolden(t, "metrics", buf.Bytes())
+}
+
+func TestCPUUsageUsec(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cpu.stat")
+	if err := os.WriteFile(path, []byte("user_usec 1\nusage_usec 42\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := cpuUsageUsec(path); err != nil || got != 42 {
+		t.Fatalf("cpuUsageUsec = %d, %v", got, err)
+	}
+	if err := os.WriteFile(path, []byte("user_usec 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cpuUsageUsec(path); err == nil {
+		t.Fatalf("expected an error without usage_usec")
+	}
+}
+
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read golden (run with -update): %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("output mismatch for %s:\n--- got\n%s--- want\n%s", path, got, want)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/control"
+	"github.com/Reidond/ccdbind/internal/procscan"
+	"github.com/Reidond/ccdbind/internal/state"
+)
+
+// applyOverrides merges pinned PIDs into the scan result and drops excluded
+// PIDs and games. Pinned or excluded PIDs that have exited, or whose PID has
+// been reused, are forgotten; the returned bool reports whether r.ov changed.
+func (r *runtime) applyOverrides(games map[string][]procscan.GameProcess) (map[string][]procscan.GameProcess, bool) {
+	changed := false
+	for _, id := range r.ov.ExcludedGames {
+		delete(games, id)
+	}
+
+	if len(r.ov.ExcludedPIDs) > 0 {
+		excluded := make(map[int]struct{}, len(r.ov.ExcludedPIDs))
+		live := r.ov.ExcludedPIDs[:0:0]
+		for _, ref := range r.ov.ExcludedPIDs {
+			if _, err := liveProcess(ref); err != nil {
+				changed = true
+				continue
+			}
+			excluded[ref.PID] = struct{}{}
+			live = append(live, ref)
+		}
+		r.ov.ExcludedPIDs = live
+		for id, procs := range games {
+			kept := procs[:0]
+			for _, gp := range procs {
+				if _, ok := excluded[gp.PID]; !ok {
+					kept = append(kept, gp)
+				}
+			}
+			if len(kept) == 0 {
+				delete(games, id)
+			} else {
+				games[id] = kept
+			}
+		}
+	}
+
+	if len(r.ov.PinnedPIDs) > 0 {
+		seen := map[int]struct{}{}
+		for _, procs := range games {
+			for _, gp := range procs {
+				seen[gp.PID] = struct{}{}
+			}
+		}
+		live := r.ov.PinnedPIDs[:0:0]
+		for _, ref := range r.ov.PinnedPIDs {
+			gp, err := liveProcess(ref)
+			if err != nil {
+				log.Printf("manual pin pid=%d gone", ref.PID)
+				changed = true
+				continue
+			}
+			live = append(live, ref)
+			if _, ok := seen[ref.PID]; ok {
+				continue
+			}
+			gp.GameID = "pid-" + strconv.Itoa(ref.PID)
+			gp.IDSource = "manual"
+			games[gp.GameID] = append(games[gp.GameID], gp)
+		}
+		r.ov.PinnedPIDs = live
+	}
+	return games, changed
+}
+
+// liveProcess returns ref's process if it is still the same instance.
+func liveProcess(ref state.PIDRef) (procscan.GameProcess, error) {
+	gp, err := procscan.ProcessInfo(ref.PID)
+	if err != nil {
+		return gp, err
+	}
+	if ref.StartTime != 0 && gp.StartTime != ref.StartTime {
+		return gp, fmt.Errorf("pid %d was reused", ref.PID)
+	}
+	return gp, nil
+}
+
+func pinPID(o *state.Overrides, pid int) error {
+	gp, err := procscan.ProcessInfo(pid)
+	if err != nil {
+		return fmt.Errorf("pid %d: %w", pid, err)
+	}
+	if err := checkOwnPID(pid); err != nil {
+		return err
+	}
+	o.PinPID(state.PIDRef{PID: pid, StartTime: gp.StartTime})
+	return nil
+}
+
+// unpinPID drops a manual pin, which works after the process has exited,
+// or excludes a running process.
+func unpinPID(o *state.Overrides, pid int) error {
+	gp, err := procscan.ProcessInfo(pid)
+	pinned := slices.ContainsFunc(o.PinnedPIDs, func(ref state.PIDRef) bool { return ref.PID == pid })
+	if err != nil && !pinned {
+		return fmt.Errorf("pid %d: %w", pid, err)
+	}
+	if err == nil && !pinned {
+		if err := checkOwnPID(pid); err != nil {
+			return err
+		}
+	}
+	o.UnpinPID(state.PIDRef{PID: pid, StartTime: gp.StartTime})
+	return nil
+}
+
+// checkOwnPID refuses processes of other users: the daemon only manages the
+// user's own processes, and a mistyped PID should not pin someone else's.
+func checkOwnPID(pid int) error {
+	owned, err := procscan.OwnedBy(pid, os.Getuid())
+	if err != nil {
+		return fmt.Errorf("pid %d: %w", pid, err)
+	}
+	if !owned {
+		return fmt.Errorf("pid %d belongs to another user", pid)
+	}
+	return nil
+}
+
+func runPause(args []string) {
+	fs := flag.NewFlagSet("ccdbind pause", flag.ExitOnError)
+	flagFor := fs.Duration("for", 0, "pause for this long (e.g. 30m). Default: until `ccdbind resume`")
+	_ = fs.Parse(args)
+
+	var until time.Time
+	if *flagFor > 0 {
+		until = time.Now().Add(*flagFor)
+	}
+	via, err := changeOverrides(
+		func(ctx context.Context, c *control.Client) error { return c.Pause(ctx, until) },
+		func(o *state.Overrides) error { o.Pause(until); return nil },
+	)
+	if err != nil {
+		fatal(err)
+	}
+	if until.IsZero() {
+		fmt.Printf("paused until resumed (%s)\n", via)
+	} else {
+		fmt.Printf("paused until %s (%s)\n", until.Format(time.RFC3339), via)
+	}
+}
+
+func runResume(args []string) {
+	fs := flag.NewFlagSet("ccdbind resume", flag.ExitOnError)
+	_ = fs.Parse(args)
+
+	via, err := changeOverrides(
+		func(ctx context.Context, c *control.Client) error { return c.Resume(ctx) },
+		func(o *state.Overrides) error { o.Resume(); return nil },
+	)
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Printf("resumed (%s)\n", via)
+}
+
+// runPin implements `ccdbind pin|unpin <appid>|--pid <pid>`.
+func runPin(args []string, pin bool) {
+	name := "unpin"
+	if pin {
+		name = "pin"
+	}
+	fs := flag.NewFlagSet("ccdbind "+name, flag.ExitOnError)
+	flagPID := fs.Int("pid", 0, "target this process instead of a game")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: ccdbind %s <appid> | --pid <pid>\n\n", name)
+		fmt.Fprintln(fs.Output(), "The argument is a game ID; pid:<n> is the same as --pid <n>.")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	var pid int
+	var gameID string
+	var err error
+	switch {
+	case *flagPID != 0 && fs.NArg() == 0:
+		pid = *flagPID
+		if pid < 0 {
+			fatal(fmt.Errorf("invalid pid %d", pid))
+		}
+	case *flagPID == 0 && fs.NArg() == 1:
+		pid, gameID, err = parsePinTarget(fs.Arg(0))
+		if err != nil {
+			fatal(err)
+		}
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var via string
+	switch {
+	case pid > 0 && pin:
+		via, err = changeOverrides(
+			func(ctx context.Context, c *control.Client) error { return c.PinPID(ctx, pid) },
+			func(o *state.Overrides) error { return pinPID(o, pid) },
+		)
+	case pid > 0:
+		via, err = changeOverrides(
+			func(ctx context.Context, c *control.Client) error { return c.UnpinPID(ctx, pid) },
+			func(o *state.Overrides) error { return unpinPID(o, pid) },
+		)
+	case pin:
+		via, err = changeOverrides(
+			func(ctx context.Context, c *control.Client) error { return c.PinGame(ctx, gameID) },
+			func(o *state.Overrides) error { o.PinGame(gameID); return nil },
+		)
+	default:
+		via, err = changeOverrides(
+			func(ctx context.Context, c *control.Client) error { return c.UnpinGame(ctx, gameID) },
+			func(o *state.Overrides) error { o.UnpinGame(gameID); return nil },
+		)
+	}
+	if err != nil {
+		fatal(err)
+	}
+	target := "game " + gameID
+	if pid > 0 {
+		target = "pid " + strconv.Itoa(pid)
+	}
+	fmt.Printf("%sned %s (%s)\n", name, target, via)
+}
+
+// parsePinTarget reads a pin argument: pid:<n> is a PID, anything else a
+// game ID, with an optional app: prefix. A bare number is a game ID even if
+// a process with that PID exists, since Steam AppIDs and PIDs overlap.
+func parsePinTarget(arg string) (pid int, gameID string, err error) {
+	arg = strings.TrimSpace(arg)
+	if rest, ok := strings.CutPrefix(arg, "pid:"); ok {
+		pid, err = strconv.Atoi(rest)
+		if err != nil || pid <= 0 {
+			return 0, "", fmt.Errorf("invalid pid %q", rest)
+		}
+		return pid, "", nil
+	}
+	arg = strings.TrimPrefix(arg, "app:")
+	if arg == "" {
+		return 0, "", errors.New("empty game id")
+	}
+	return 0, arg, nil
+}
+
+// changeOverrides applies a change through the running daemon, or directly
+// to the state file when no daemon is reachable. It reports which path was
+// taken.
+func changeOverrides(online func(context.Context, *control.Client) error, offline func(*state.Overrides) error) (string, error) {
+	c, err := control.Dial()
+	if err == nil {
+		defer c.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return "via daemon", online(ctx, c)
+	}
+	if !errors.Is(err, control.ErrNotRunning) {
+		fmt.Fprintf(os.Stderr, "warning: cannot reach daemon (%v); editing state file\n", err)
+	}
+
+	statePath, err := state.DefaultPath()
+	if err != nil {
+		return "", err
+	}
+	st, err := state.Load(statePath)
+	if err != nil {
+		return "", err
+	}
+	if err := offline(&st.Overrides); err != nil {
+		return "", err
+	}
+	if err := state.Save(statePath, st); err != nil {
+		return "", err
+	}
+	return "daemon not running; saved to " + statePath, nil
+}
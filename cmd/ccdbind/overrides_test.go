@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestParsePinTarget(t *testing.T) {
+	self := strconv.Itoa(os.Getpid())
+	tests := []struct {
+		arg     string
+		pid     int
+		gameID  string
+		wantErr bool
+	}{
+		{arg: "570", gameID: "570"},
+		// A number that is also a live PID is still a game ID.
+		{arg: self, gameID: self},
+		{arg: " app:570 ", gameID: "570"},
+		{arg: "pid:" + self, pid: os.Getpid()},
+		{arg: "pid:0", wantErr: true},
+		{arg: "pid:abc", wantErr: true},
+		{arg: "app:", wantErr: true},
+		{arg: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		pid, gameID, err := parsePinTarget(tt.arg)
+		if (err != nil) != tt.wantErr || pid != tt.pid || gameID != tt.gameID {
+			t.Errorf("parsePinTarget(%q) = %d, %q, %v; want %d, %q, err=%v", tt.arg, pid, gameID, err, tt.pid, tt.gameID, tt.wantErr)
+		}
+	}
+}
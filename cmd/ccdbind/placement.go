@@ -0,0 +1,176 @@
+package main
+
+import (
+	"path/filepath"
+	"sort"
+
+	"github.com/Reidond/ccdbind/internal/history"
+	"github.com/Reidond/ccdbind/internal/procscan"
+	"github.com/Reidond/ccdbind/internal/topology"
+)
+
+// clockTicks is USER_HZ, the unit of utime/stime in /proc/<pid>/stat. It is
+// 100 on every Linux architecture ccdbind runs on.
+const clockTicks = 100
+
+const maxOffGameTIDs = 16
+
+// threadKey identifies a thread across TID reuse within a session.
+type threadKey struct {
+	tid   int
+	start uint64
+}
+
+// placementSampler accumulates a game's placement samples over a session.
+// Counters are cumulative per thread, so the first and latest value of
+// each thread are kept and their difference is summed. Threads are keyed by
+// TID and start time: a reused TID is a new thread whose counters start
+// below the old one's. Threads missing from a sample have ended; their
+// deltas move into the ended* totals so the maps only hold live threads.
+type placementSampler struct {
+	samples int
+	offGame int
+	offTIDs map[int]struct{}
+
+	firstTicks, lastTicks map[threadKey]uint64
+	firstMig, lastMig     map[threadKey]uint64
+	migKnown              bool
+
+	endedThreads int
+	endedTicks   uint64
+	endedMig     uint64
+
+	scopeFirst, scopeLast uint64
+	scopeSeen             bool
+}
+
+func newPlacementSampler() *placementSampler {
+	return &placementSampler{
+		offTIDs:    map[int]struct{}{},
+		firstTicks: map[threadKey]uint64{},
+		lastTicks:  map[threadKey]uint64{},
+		firstMig:   map[threadKey]uint64{},
+		lastMig:    map[threadKey]uint64{},
+	}
+}
+
+// addThreads records one sample of all of the game's threads.
+func (ps *placementSampler) addThreads(threads []procscan.ThreadSched, gameCPUs []int) {
+	seen := make(map[threadKey]struct{}, len(threads))
+	for _, ts := range threads {
+		ps.samples++
+		if !topology.ContainsCPU(gameCPUs, ts.Processor) {
+			ps.offGame++
+			if len(ps.offTIDs) < maxOffGameTIDs {
+				ps.offTIDs[ts.TID] = struct{}{}
+			}
+		}
+		key := threadKey{ts.TID, ts.StartTime}
+		seen[key] = struct{}{}
+		if _, ok := ps.firstTicks[key]; !ok {
+			ps.firstTicks[key] = ts.CPUTicks
+		}
+		ps.lastTicks[key] = ts.CPUTicks
+		if ts.HasMigrations {
+			ps.migKnown = true
+			if _, ok := ps.firstMig[key]; !ok {
+				ps.firstMig[key] = ts.Migrations
+			}
+			ps.lastMig[key] = ts.Migrations
+		}
+	}
+
+	for key, last := range ps.lastTicks {
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		ps.endedThreads++
+		ps.endedTicks += last - ps.firstTicks[key]
+		delete(ps.firstTicks, key)
+		delete(ps.lastTicks, key)
+	}
+	for key, last := range ps.lastMig {
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		ps.endedMig += last - ps.firstMig[key]
+		delete(ps.firstMig, key)
+		delete(ps.lastMig, key)
+	}
+}
+
+func (ps *placementSampler) addScopeUsage(usec uint64) {
+	if !ps.scopeSeen {
+		ps.scopeFirst, ps.scopeSeen = usec, true
+	}
+	ps.scopeLast = usec
+}
+
+func (ps *placementSampler) summary() history.Placement {
+	p := history.Placement{
+		Samples:         ps.samples,
+		OffGameCPU:      ps.offGame,
+		Threads:         ps.endedThreads + len(ps.firstTicks),
+		Migrations:      ps.endedMig,
+		MigrationsKnown: ps.migKnown,
+	}
+	ticks := ps.endedTicks
+	for key, last := range ps.lastTicks {
+		ticks += last - ps.firstTicks[key]
+	}
+	p.CPUSeconds = float64(ticks) / clockTicks
+	for key, last := range ps.lastMig {
+		p.Migrations += last - ps.firstMig[key]
+	}
+	if ps.scopeSeen {
+		p.ScopeCPUSeconds = float64(ps.scopeLast-ps.scopeFirst) / 1e6
+	}
+	for tid := range ps.offTIDs {
+		p.OffGameTIDs = append(p.OffGameTIDs, tid)
+	}
+	sort.Ints(p.OffGameTIDs)
+	return p
+}
+
+// samplePlacement records one placement sample for every active game.
+func (r *runtime) samplePlacement(games map[string][]procscan.GameProcess, scopes []scopeCgroup) {
+	_, gameCPUs, err := topology.CanonicalizeCPUList(r.gameCPUs)
+	if err != nil {
+		return
+	}
+	scopeDir := make(map[string]string, len(scopes))
+	for _, sc := range scopes {
+		scopeDir[sc.gameID] = sc.dir
+	}
+	for id, procs := range games {
+		g := r.active[id]
+		if g == nil || len(procs) == 0 {
+			continue
+		}
+		if g.placement == nil {
+			g.placement = newPlacementSampler()
+		}
+		var threads []procscan.ThreadSched
+		for _, gp := range procs {
+			ts, err := procscan.ThreadSchedStats(gp.PID)
+			if err != nil {
+				continue
+			}
+			threads = append(threads, ts...)
+		}
+		g.placement.addThreads(threads, gameCPUs)
+		if dir, ok := scopeDir[id]; ok {
+			if usec, err := cpuUsageUsec(filepath.Join(dir, "cpu.stat")); err == nil {
+				g.placement.addScopeUsage(usec)
+			}
+		}
+	}
+}
+
+func (g *activeGame) placementSummary() *history.Placement {
+	if g.placement == nil {
+		return nil
+	}
+	p := g.placement.summary()
+	return &p
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Reidond/ccdbind/internal/procscan"
+)
+
+func TestPlacementSamplerSummary(t *testing.T) {
+	ps := newPlacementSampler()
+	gameCPUs := []int{8, 9}
+	ps.addThreads([]procscan.ThreadSched{
+		{TID: 10, StartTime: 100, Processor: 8, CPUTicks: 1000, Migrations: 50, HasMigrations: true},
+		{TID: 11, StartTime: 100, Processor: 0, CPUTicks: 200, Migrations: 5, HasMigrations: true},
+	}, gameCPUs)
+	ps.addThreads([]procscan.ThreadSched{
+		{TID: 10, StartTime: 100, Processor: 9, CPUTicks: 1300, Migrations: 70, HasMigrations: true},
+		{TID: 11, StartTime: 100, Processor: 8, CPUTicks: 250, Migrations: 6, HasMigrations: true},
+	}, gameCPUs)
+	ps.addScopeUsage(1_000_000)
+	ps.addScopeUsage(4_500_000)
+
+	p := ps.summary()
+	if p.Samples != 4 || p.OffGameCPU != 1 || p.Threads != 2 {
+		t.Fatalf("unexpected counts: %+v", p)
+	}
+	if p.CPUSeconds != 3.5 || p.Migrations != 21 || !p.MigrationsKnown {
+		t.Fatalf("unexpected deltas: %+v", p)
+	}
+	if p.ScopeCPUSeconds != 3.5 {
+		t.Fatalf("unexpected scope usage: %v", p.ScopeCPUSeconds)
+	}
+	if len(p.OffGameTIDs) != 1 || p.OffGameTIDs[0] != 11 {
+		t.Fatalf("unexpected off-game TIDs: %v", p.OffGameTIDs)
+	}
+}
+
+func TestPlacementSamplerTIDReuse(t *testing.T) {
+	ps := newPlacementSampler()
+	gameCPUs := []int{8}
+	ps.addThreads([]procscan.ThreadSched{
+		{TID: 10, StartTime: 100, Processor: 8, CPUTicks: 5000, Migrations: 900, HasMigrations: true},
+	}, gameCPUs)
+	ps.addThreads([]procscan.ThreadSched{
+		{TID: 10, StartTime: 100, Processor: 8, CPUTicks: 5100, Migrations: 910, HasMigrations: true},
+	}, gameCPUs)
+	// The thread exits and a new one gets TID 10 with lower counters.
+	ps.addThreads([]procscan.ThreadSched{
+		{TID: 10, StartTime: 700, Processor: 8, CPUTicks: 3, Migrations: 1, HasMigrations: true},
+	}, gameCPUs)
+	ps.addThreads([]procscan.ThreadSched{
+		{TID: 10, StartTime: 700, Processor: 8, CPUTicks: 53, Migrations: 4, HasMigrations: true},
+	}, gameCPUs)
+
+	p := ps.summary()
+	if p.Threads != 2 {
+		t.Fatalf("reused TID not counted as a new thread: %+v", p)
+	}
+	if p.CPUSeconds != 1.5 || p.Migrations != 13 {
+		t.Fatalf("unexpected deltas across TID reuse: %+v", p)
+	}
+}
+
+func TestPlacementSamplerEndedThreads(t *testing.T) {
+	ps := newPlacementSampler()
+	gameCPUs := []int{8}
+	main := procscan.ThreadSched{TID: 10, StartTime: 100, Processor: 8, HasMigrations: true}
+	// A long-lived main thread next to a worker that is replaced every
+	// sample, as Wine's thread pools do.
+	for i := 0; i < 1000; i++ {
+		main.CPUTicks, main.Migrations = uint64(10*i), uint64(i)
+		worker := procscan.ThreadSched{TID: 1000 + i, StartTime: uint64(200 + i), Processor: 8, CPUTicks: 5, Migrations: 2, HasMigrations: true}
+		ps.addThreads([]procscan.ThreadSched{main, worker}, gameCPUs)
+	}
+	for _, m := range []map[threadKey]uint64{ps.firstTicks, ps.lastTicks, ps.firstMig, ps.lastMig} {
+		if len(m) > 2 {
+			t.Fatalf("per-thread maps grew to %d entries", len(m))
+		}
+	}
+
+	p := ps.summary()
+	// Each worker was seen once, so it adds nothing; main ran 9990 ticks
+	// and migrated 999 times.
+	if p.Threads != 1001 || p.CPUSeconds != 99.9 || p.Migrations != 999 {
+		t.Fatalf("unexpected summary: %+v", p)
+	}
+
+	// A worker seen twice before it ends keeps its delta.
+	ps.addThreads([]procscan.ThreadSched{main, {TID: 5000, StartTime: 9000, Processor: 8, CPUTicks: 1, Migrations: 1, HasMigrations: true}}, gameCPUs)
+	ps.addThreads([]procscan.ThreadSched{main, {TID: 5000, StartTime: 9000, Processor: 8, CPUTicks: 51, Migrations: 4, HasMigrations: true}}, gameCPUs)
+	ps.addThreads([]procscan.ThreadSched{main}, gameCPUs)
+	p = ps.summary()
+	if p.Threads != 1002 || p.CPUSeconds != 100.4 || p.Migrations != 1002 {
+		t.Fatalf("unexpected summary after an ended worker: %+v", p)
+	}
+	if len(ps.lastTicks) != 1 || len(ps.lastMig) != 1 {
+		t.Fatalf("ended threads kept: %d ticks, %d migrations", len(ps.lastTicks), len(ps.lastMig))
+	}
+}
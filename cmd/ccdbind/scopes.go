@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/procscan"
+	"github.com/Reidond/ccdbind/internal/state"
+	"github.com/Reidond/ccdbind/internal/systemdctl"
+)
+
+const gameScopePattern = "game-*.scope"
+
+// adoptScopes reconciles game-*.scope units left behind by a previous run.
+// Scopes that still hold processes of a running game are adopted into
+// pidToUnit; empty scopes and scopes whose game is gone are stopped.
+func adoptScopes(ctx context.Context, r *runtime, be systemdctl.Backend, games map[string][]procscan.GameProcess) state.ScopeReport {
+	report := state.ScopeReport{At: time.Now()}
+
+	ctx2, cancel := context.WithTimeout(ctx, 10*time.Second)
+	units, err := be.ListUnitsByPatterns(ctx2, nil, []string{gameScopePattern})
+	cancel()
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("list scopes: %v", err))
+		return report
+	}
+	sort.Slice(units, func(i, j int) bool { return units[i].Name < units[j].Name })
+
+	byUnit := gameProcsByUnit(games)
+	for _, u := range units {
+		ctx2, cancel := context.WithTimeout(ctx, 5*time.Second)
+		procs, err := be.GetUnitProcesses(ctx2, u.Name)
+		cancel()
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("processes of %s: %v", u.Name, err))
+			continue
+		}
+
+		live := byUnit[u.Name]
+		adopted := 0
+		for _, p := range procs {
+			gp, ok := live[int(p.PID)]
+			if !ok {
+				continue
+			}
+			r.pidToUnit[gp.PID] = pidRecord{gameID: gp.GameID, unit: u.Name, startTime: gp.StartTime}
+			adopted++
+		}
+		if adopted > 0 || len(live) > 0 {
+			log.Printf("adopted scope %s pids=%d", u.Name, adopted)
+			report.Adopted = append(report.Adopted, u.Name)
+			continue
+		}
+
+		log.Printf("stopping stale scope %s processes=%d", u.Name, len(procs))
+		ctx2, cancel = context.WithTimeout(ctx, 10*time.Second)
+		err = be.StopUnit(ctx2, u.Name)
+		cancel()
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("stop %s: %v", u.Name, err))
+			continue
+		}
+		report.Stopped = append(report.Stopped, u.Name)
+	}
+	return report
+}
+
+// gameProcsByUnit indexes scanned game processes by the scope unit they
+// belong in.
+func gameProcsByUnit(games map[string][]procscan.GameProcess) map[string]map[int]procscan.GameProcess {
+	out := make(map[string]map[int]procscan.GameProcess, len(games))
+	for gameID, procs := range games {
+		unit := systemdctl.UnitNameForGameID(gameID)
+		m, ok := out[unit]
+		if !ok {
+			m = make(map[int]procscan.GameProcess, len(procs))
+			out[unit] = m
+		}
+		for _, gp := range procs {
+			m[gp.PID] = gp
+		}
+	}
+	return out
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
@@ -10,6 +11,8 @@ import (
 	"time"
 
 	"github.com/Reidond/ccdbind/internal/config"
+	"github.com/Reidond/ccdbind/internal/control"
+	"github.com/Reidond/ccdbind/internal/history"
 	"github.com/Reidond/ccdbind/internal/procscan"
 	"github.com/Reidond/ccdbind/internal/state"
 	"github.com/Reidond/ccdbind/internal/systemdctl"
@@ -27,7 +30,17 @@ type statusGameProc struct {
 	Exe         string `json:"exe"`
 	GameID      string `json:"game_id"`
 	IDSource    string `json:"id_source"`
+	Mode        string `json:"mode"`
 	AllowedCPUs string `json:"allowed_cpus,omitempty"`
+	Cgroup      string `json:"cgroup,omitempty"`
+	InScope     bool   `json:"in_scope"`
+}
+
+type statusScope struct {
+	Unit        string `json:"unit"`
+	ActiveState string `json:"active_state"`
+	SubState    string `json:"sub_state"`
+	Orphaned    bool   `json:"orphaned"`
 }
 
 type statusProgramSummary struct {
@@ -47,14 +60,24 @@ type statusOutput struct {
 
 	OSCPUs   string `json:"os_cpus,omitempty"`
 	GameCPUs string `json:"game_cpus,omitempty"`
+	Backend  string `json:"backend"`
 
-	State  state.File             `json:"state"`
-	Slices []statusSlice          `json:"slices"`
-	Games  []statusGameProc       `json:"games,omitempty"`
-	All    []statusProgramSummary `json:"all,omitempty"`
-	Errors []string               `json:"errors,omitempty"`
+	Daemon   *control.Status        `json:"daemon,omitempty"`
+	State    state.File             `json:"state"`
+	Slices   []statusSlice          `json:"slices"`
+	Games    []statusGameProc       `json:"games,omitempty"`
+	Scopes   []statusScope          `json:"scopes,omitempty"`
+	All      []statusProgramSummary `json:"all,omitempty"`
+	Errors   []string               `json:"errors,omitempty"`
+	Warnings []string               `json:"warnings,omitempty"`
+
+	// Sessions holds recent history for --games.
+	Sessions      []history.Session `json:"sessions,omitempty"`
+	ShowPlacement bool              `json:"-"`
 }
 
+const statusRecentSessions = 10
+
 func runStatus(args []string) {
 	fs := flag.NewFlagSet("ccdbind status", flag.ExitOnError)
 	flagJSON := fs.Bool("json", false, "output JSON")
@@ -62,6 +85,9 @@ func runStatus(args []string) {
 	flagOnlyGames := fs.Bool("only-games", false, "alias for --filter=games")
 	flagAll := fs.Bool("all", false, "alias for --filter=all")
 	flagConfig := fs.String("config", "", "config file path (TOML). Default: XDG config path")
+	flagGames := fs.Bool("games", false, "show the CPU placement report of running games and recent sessions")
+	flagWatch := fs.Bool("watch", false, "refresh in place with per-CPU load and an event log")
+	flagWatchInterval := fs.Duration("watch-interval", 2*time.Second, "refresh interval for --watch")
 	_ = fs.Parse(args)
 
 	filter := strings.ToLower(strings.TrimSpace(*flagFilter))
@@ -89,24 +115,94 @@ func runStatus(args []string) {
 	if configPath == "" {
 		configPath = defaultCfgPath
 	}
+	opts := statusOptions{filter: filter, configPath: configPath, placement: *flagGames}
 
-	statePath, err := state.DefaultPath()
+	if *flagWatch {
+		if *flagJSON {
+			fatal(fmt.Errorf("--watch cannot be combined with --json"))
+		}
+		runWatch(opts, *flagWatchInterval)
+		return
+	}
+
+	out, err := gatherStatus(opts, nil)
 	if err != nil {
 		fatal(err)
 	}
+	if *flagJSON {
+		b, _ := json.MarshalIndent(out, "", "  ")
+		fmt.Println(string(b))
+		return
+	}
+	printStatusHuman(out)
+}
+
+type statusOptions struct {
+	filter     string
+	configPath string
+	// placement adds the --games placement report and recent sessions.
+	placement bool
+}
+
+// statusBackend is the backend status reads slices and scopes through.
+// --watch keeps one for the whole run instead of reconnecting every
+// refresh; a failed connection is retried on the next one.
+type statusBackend struct {
+	be        systemdctl.Backend
+	cpusetErr error
+}
+
+func (sb *statusBackend) open(cfg config.Config) (systemdctl.Backend, error) {
+	if sb.be != nil {
+		return sb.be, nil
+	}
+	be, err := newBackend(cfg, false)
+	if err != nil {
+		return nil, err
+	}
+	sb.be, sb.cpusetErr = be, be.CheckCPUSet()
+	return be, nil
+}
+
+func (sb *statusBackend) Close() {
+	if sb.be != nil {
+		sb.be.Close()
+		sb.be = nil
+	}
+}
+
+// gatherStatus collects everything `status` shows. It is shared by the
+// one-shot output and --watch, which passes the backend it keeps; with a
+// nil sb a backend is opened for this call only.
+func gatherStatus(opts statusOptions, sb *statusBackend) (statusOutput, error) {
+	if sb == nil {
+		sb = &statusBackend{}
+		defer sb.Close()
+	}
+	filter, configPath := opts.filter, opts.configPath
+	statePath, err := state.DefaultPath()
+	if err != nil {
+		return statusOutput{}, err
+	}
 
 	cfg, err := config.Load(configPath)
 	if err != nil {
-		fatal(err)
+		return statusOutput{}, err
 	}
 
 	st, err := state.Load(statePath)
 	if err != nil {
-		fatal(err)
+		return statusOutput{}, err
 	}
 
+	daemon, daemonErr := queryDaemon()
+
 	osCPUs := strings.TrimSpace(st.OSCPUs)
 	gameCPUs := strings.TrimSpace(st.GameCPUs)
+	if daemon != nil {
+		osCPUs = daemon.OSCPUs
+		gameCPUs = daemon.GameCPUs
+	}
 	if osCPUs == "" || gameCPUs == "" {
 		resOS, resGame, err := resolveCPUs(cfg)
 		if err == nil {
@@ -127,24 +223,38 @@ func runStatus(args []string) {
 		OSCPUs:      osCPUs,
 		GameCPUs:    gameCPUs,
 		State:       st,
+		Daemon:      daemon,
+	}
+	if daemonErr != nil && !errors.Is(daemonErr, control.ErrNotRunning) {
+		out.Errors = append(out.Errors, fmt.Sprintf("query daemon: %v", daemonErr))
 	}
 
-	sys := systemdctl.Systemctl{}
-	slices := slicesToPin(cfg)
-	for _, unit := range slices {
-		ss := statusSlice{Unit: unit}
-		if st.OriginalAllowedCPUs != nil {
-			ss.OriginalAllowed = st.OriginalAllowedCPUs[unit]
+	be, err := sb.open(cfg)
+	if err != nil {
+		out.Backend = cfg.Backend
+		out.Errors = append(out.Errors, err.Error())
+	} else {
+		out.Backend = be.Name()
+		if err := sb.cpusetErr; err != nil {
+			out.Warnings = append(out.Warnings, fmt.Sprintf("%s backend cannot enforce CPU affinity: %v", be.Name(), err))
 		}
-		ctx2, cancel := systemdctl.DefaultContext()
-		val, err := sys.GetAllowedCPUs(ctx2, unit)
-		cancel()
-		if err != nil {
-			ss.ReadAllowedCPUErr = err.Error()
-		} else {
-			ss.AllowedCPUs = val
+
+		slices := cfg.OSSlices()
+		for _, unit := range slices {
+			ss := statusSlice{Unit: unit}
+			if st.OriginalAllowedCPUs != nil {
+				ss.OriginalAllowed = st.OriginalAllowedCPUs[unit]
+			}
+			ctx2, cancel := systemdctl.DefaultContext()
+			val, err := be.GetAllowedCPUs(ctx2, unit)
+			cancel()
+			if err != nil {
+				ss.ReadAllowedCPUErr = err.Error()
+			} else {
+				ss.AllowedCPUs = val
+			}
+			out.Slices = append(out.Slices, ss)
 		}
-		out.Slices = append(out.Slices, ss)
 	}
 
 	uid := os.Getuid()
@@ -159,11 +269,18 @@ func runStatus(args []string) {
 				gameIDs = append(gameIDs, id)
 			}
 			sort.Strings(gameIDs)
+			if be != nil {
+				out.Scopes, err = listGameScopes(be, games)
+				if err != nil {
+					out.Errors = append(out.Errors, fmt.Sprintf("list scopes: %v", err))
+				}
+			}
 			for _, gameID := range gameIDs {
 				procs := games[gameID]
 				sort.Slice(procs, func(i, j int) bool { return procs[i].PID < procs[j].PID })
 				for _, gp := range procs {
-					p := statusGameProc{PID: gp.PID, Exe: gp.Exe, GameID: gp.GameID, IDSource: gp.IDSource}
+					p := statusGameProc{PID: gp.PID, Exe: gp.Exe, GameID: gp.GameID, IDSource: gp.IDSource, Mode: cfg.ModeFor(gameID), Cgroup: gp.Cgroup}
+					p.InScope = procscan.CgroupInUnit(gp.Cgroup, systemdctl.UnitNameForGameID(gameID))
 					if allowed, err := procscan.AllowedCPUs(gp.PID); err == nil {
 						p.AllowedCPUs = allowed
 					}
@@ -222,18 +339,73 @@ func runStatus(args []string) {
 		}
 	}
 
-	if *flagJSON {
-		b, _ := json.MarshalIndent(out, "", "  ")
-		fmt.Println(string(b))
-		return
+	if opts.placement {
+		out.ShowPlacement = true
+		if path, err := history.DefaultPath(); err != nil {
+			out.Errors = append(out.Errors, fmt.Sprintf("history: %v", err))
+		} else if sessions, err := history.Load(path); err != nil {
+			out.Errors = append(out.Errors, fmt.Sprintf("history: %v", err))
+		} else {
+			if len(sessions) > statusRecentSessions {
+				sessions = sessions[len(sessions)-statusRecentSessions:]
+			}
+			out.Sessions = sessions
+		}
 	}
+	return out, nil
+}
 
-	printStatusHuman(out)
+// queryDaemon fetches the running daemon's in-memory status. It returns
+// control.ErrNotRunning when no daemon is on the bus.
+func queryDaemon() (*control.Status, error) {
+	c, err := control.Dial()
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	ctx, cancel := systemdctl.DefaultContext()
+	defer cancel()
+	st, err := c.GetStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &st, nil
 }
 
 func printStatusHuman(out statusOutput) {
 	fmt.Printf("state: %s\n", out.StatePath)
+	if d := out.Daemon; d != nil {
+		fmt.Printf("daemon: running pid=%d since=%s backend=%s dry_run=%v\n", d.PID, d.StartedAt.Format(time.RFC3339), d.Backend, d.DryRun)
+		if !d.LastTick.IsZero() {
+			fmt.Printf("  last_tick: %s (%.1fms)\n", d.LastTick.Format(time.RFC3339), d.LastTickMillis)
+		}
+		if d.LastError != "" {
+			fmt.Printf("  last_error: %s (at %s)\n", d.LastError, d.LastErrorAt.Format(time.RFC3339))
+		}
+		for _, g := range d.Games {
+			pids := make([]int, 0, len(g.Procs))
+			for _, p := range g.Procs {
+				pids = append(pids, p.PID)
+			}
+			fmt.Printf("  tracking: game_id=%s mode=%s unit=%s pids=%v\n", g.ID, g.Mode, g.Unit, pids)
+		}
+		for _, l := range d.Launchers {
+			fmt.Printf("  launcher: ccdpin pid=%d game_id=%s since=%s\n", l.PID, l.GameID, l.Since.Format(time.RFC3339))
+		}
+		if d.SlicesHeldByCcdpin {
+			fmt.Println("  slices: pinned by ccdpin (daemon leaves them alone)")
+		}
+		for _, p := range d.Pending {
+			fmt.Printf("  pending: %s game_id=%s due=%s (in %s)\n", p.Transition, p.GameID, p.Due.Format(time.RFC3339), p.Due.Sub(out.GeneratedAt).Round(time.Second))
+		}
+	} else {
+		fmt.Println("daemon: not running")
+	}
 	fmt.Printf("pin_applied: %v\n", out.State.PinApplied)
+	printOverrides(out.State.Overrides, out.GeneratedAt)
+	if out.Backend != "" {
+		fmt.Printf("backend: %s\n", out.Backend)
+	}
 	if out.OSCPUs != "" {
 		fmt.Printf("os_cpus: %s\n", out.OSCPUs)
 	}
@@ -265,11 +437,35 @@ func printStatusHuman(out statusOutput) {
 				if allowed == "" {
 					allowed = "?"
 				}
-				fmt.Printf("  pid=%d exe=%s game_id=%s src=%s allowed=%s\n", g.PID, g.Exe, g.GameID, g.IDSource, allowed)
+				fmt.Printf("  pid=%d exe=%s game_id=%s src=%s mode=%s allowed=%s in_scope=%v\n", g.PID, g.Exe, g.GameID, g.IDSource, g.Mode, allowed, g.InScope)
+				if g.Cgroup != "" {
+					fmt.Printf("    cgroup=%s\n", g.Cgroup)
+				}
 			}
 		}
 	}
 
+	if out.ShowPlacement {
+		printPlacement(out)
+	}
+
+	if len(out.Scopes) > 0 {
+		fmt.Println("scopes:")
+		for _, sc := range out.Scopes {
+			line := fmt.Sprintf("  %s: %s/%s", sc.Unit, sc.ActiveState, sc.SubState)
+			if sc.Orphaned {
+				line += " (orphaned)"
+			}
+			fmt.Println(line)
+		}
+	}
+	if gc := out.State.LastScopeGC; gc != nil && (len(gc.Adopted) > 0 || len(gc.Stopped) > 0 || len(gc.Errors) > 0) {
+		fmt.Printf("scope_gc: at=%s adopted=%v stopped=%v\n", gc.At.Format(time.RFC3339), gc.Adopted, gc.Stopped)
+		for _, e := range gc.Errors {
+			fmt.Printf("  error: %s\n", e)
+		}
+	}
+
 	if out.Filter == "all" {
 		if len(out.All) == 0 {
 			fmt.Println("affected: none")
@@ -281,6 +477,13 @@ func printStatusHuman(out statusOutput) {
 		}
 	}
 
+	if len(out.Warnings) > 0 {
+		fmt.Println("warnings:")
+		for _, w := range out.Warnings {
+			fmt.Printf("  %s\n", w)
+		}
+	}
+
 	if len(out.Errors) > 0 {
 		fmt.Println("errors:")
 		for _, e := range out.Errors {
@@ -288,3 +491,87 @@ func printStatusHuman(out statusOutput) {
 		}
 	}
 }
+
+// listGameScopes reports the game-*.scope units known to the user manager,
+// flagging those that no longer belong to a running game.
+func listGameScopes(be systemdctl.Backend, games map[string][]procscan.GameProcess) ([]statusScope, error) {
+	ctx, cancel := systemdctl.DefaultContext()
+	defer cancel()
+	units, err := be.ListUnitsByPatterns(ctx, nil, []string{gameScopePattern})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(units, func(i, j int) bool { return units[i].Name < units[j].Name })
+
+	byUnit := gameProcsByUnit(games)
+	out := make([]statusScope, 0, len(units))
+	for _, u := range units {
+		_, live := byUnit[u.Name]
+		out = append(out, statusScope{Unit: u.Name, ActiveState: u.ActiveState, SubState: u.SubState, Orphaned: !live})
+	}
+	return out, nil
+}
+
+func printOverrides(o state.Overrides, now time.Time) {
+	switch {
+	case o.IsPaused(now) && o.PausedUntil.IsZero():
+		fmt.Println("paused: until resumed")
+	case o.IsPaused(now):
+		fmt.Printf("paused: until %s\n", o.PausedUntil.Format(time.RFC3339))
+	}
+	for _, ref := range o.PinnedPIDs {
+		fmt.Printf("pinned: pid=%d\n", ref.PID)
+	}
+	for _, id := range o.PinnedGames {
+		fmt.Printf("pinned: game_id=%s\n", id)
+	}
+	for _, ref := range o.ExcludedPIDs {
+		fmt.Printf("excluded: pid=%d\n", ref.PID)
+	}
+	for _, id := range o.ExcludedGames {
+		fmt.Printf("excluded: game_id=%s\n", id)
+	}
+}
+
+func printPlacement(out statusOutput) {
+	fmt.Println("placement:")
+	if out.Daemon == nil {
+		fmt.Println("  (daemon not running; no live samples)")
+	} else if len(out.Daemon.Games) == 0 {
+		fmt.Println("  no running games")
+	}
+	if out.Daemon != nil {
+		for _, g := range out.Daemon.Games {
+			fmt.Printf("  %s running %s: %s\n", g.ID, out.GeneratedAt.Sub(g.StartedAt).Round(time.Second), formatPlacement(g.Placement))
+		}
+	}
+	if len(out.Sessions) == 0 {
+		return
+	}
+	fmt.Println("recent sessions:")
+	for i := len(out.Sessions) - 1; i >= 0; i-- {
+		s := out.Sessions[i]
+		name := s.GameID
+		if s.Name != "" {
+			name += " (" + s.Name + ")"
+		}
+		fmt.Printf("  %s %s %s placed=%s: %s\n", s.Start.Local().Format("2006-01-02 15:04"), name, s.Duration().Round(time.Second), yesNo(s.Placed), formatPlacement(s.Placement))
+	}
+}
+
+func formatPlacement(p *history.Placement) string {
+	if p == nil || p.Samples == 0 {
+		return "no samples"
+	}
+	line := fmt.Sprintf("threads=%d samples=%d off_game_cpu=%d (%.1f%%) cpu=%.1fs", p.Threads, p.Samples, p.OffGameCPU, 100*p.OffGameRatio(), p.CPUSeconds)
+	if p.ScopeCPUSeconds > 0 {
+		line += fmt.Sprintf(" scope_cpu=%.1fs", p.ScopeCPUSeconds)
+	}
+	if p.MigrationsKnown {
+		line += fmt.Sprintf(" migrations=%d", p.Migrations)
+	}
+	if len(p.OffGameTIDs) > 0 {
+		line += fmt.Sprintf(" off_game_tids=%v", p.OffGameTIDs)
+	}
+	return line
+}
@@ -0,0 +1,591 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/procscan"
+	"github.com/Reidond/ccdbind/internal/topology"
+)
+
+const (
+	watchEventLog       = 12
+	watchMaxThreadLines = 5
+	watchCPUsPerRow     = 4
+	watchBarWidth       = 8
+)
+
+// ANSI sequences used by --watch. Colors are only emitted when enabled.
+const (
+	ansiAltScreenOn  = "\x1b[?1049h"
+	ansiAltScreenOff = "\x1b[?1049l"
+	ansiHideCursor   = "\x1b[?25l"
+	ansiShowCursor   = "\x1b[?25h"
+	ansiHome         = "\x1b[H"
+	ansiClearLine    = "\x1b[K"
+	ansiClearBelow   = "\x1b[J"
+
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiDim    = "\x1b[2m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiCyan   = "\x1b[36m"
+)
+
+// watchView is the state --watch carries between refreshes: the previous
+// /proc/stat sample for utilisation and the previous snapshot for the event
+// log.
+type watchView struct {
+	tty   bool
+	color bool
+
+	prevCPU map[int]procscan.CPUTime
+	prev    *watchSnapshot
+	events  []watchEvent
+}
+
+type watchEvent struct {
+	at  time.Time
+	msg string
+}
+
+// watchSnapshot is the part of a refresh that is compared with the previous
+// one to produce events.
+type watchSnapshot struct {
+	daemonPID  int
+	pinApplied bool
+	paused     bool
+	games      map[string]int    // game ID -> process count
+	slices     map[string]string // unit -> AllowedCPUs
+	scopes     map[string]string // unit -> active/sub state
+	offGame    map[string]int    // game ID -> threads on non-GAME CPUs
+	intruders  int               // other threads on GAME CPUs
+	errors     map[string]struct{}
+}
+
+// threadPlacement is a thread whose current CPU is outside its group's set.
+type threadPlacement struct {
+	pid, tid int
+	comm     string
+	cpu      int
+}
+
+type watchGame struct {
+	id, mode, unit string
+	pids           []int
+	threads        int
+	offGame        []threadPlacement
+}
+
+// intruder aggregates the non-game threads running on GAME CPUs by
+// executable.
+type intruder struct {
+	exe     string
+	threads int
+	cpus    []int
+}
+
+func runWatch(opts statusOptions, interval time.Duration) {
+	if interval < 200*time.Millisecond {
+		interval = 200 * time.Millisecond
+	}
+	v := &watchView{tty: isTerminal(os.Stdout)}
+	v.color = v.tty && os.Getenv("NO_COLOR") == ""
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigc)
+
+	if v.tty {
+		fmt.Print(ansiAltScreenOn + ansiHideCursor)
+		defer fmt.Print(ansiShowCursor + ansiAltScreenOff)
+	}
+	v.event(time.Now(), fmt.Sprintf("watching every %s", interval))
+
+	sb := &statusBackend{}
+	defer sb.Close()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		v.refresh(os.Stdout, opts, sb, interval)
+		select {
+		case <-sigc:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// isTerminal reports whether f is a character device, which is as close as
+// the standard library gets to isatty.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func (v *watchView) event(at time.Time, msg string) {
+	v.events = append(v.events, watchEvent{at: at, msg: msg})
+	if len(v.events) > watchEventLog {
+		v.events = v.events[len(v.events)-watchEventLog:]
+	}
+}
+
+func (v *watchView) paint(s, code string) string {
+	if !v.color || s == "" {
+		return s
+	}
+	return code + s + ansiReset
+}
+
+func (v *watchView) refresh(w io.Writer, opts statusOptions, sb *statusBackend, interval time.Duration) {
+	now := time.Now()
+	out, err := gatherStatus(opts, sb)
+	if err != nil {
+		out = statusOutput{GeneratedAt: now, Errors: []string{err.Error()}}
+	}
+	_, osCPUs, _ := topology.CanonicalizeCPUList(out.OSCPUs)
+	_, gameCPUs, _ := topology.CanonicalizeCPUList(out.GameCPUs)
+
+	games := watchGames(out, gameCPUs)
+	intruders, intruderThreads := watchIntruders(out, gameCPUs)
+	cur := newWatchSnapshot(out, games, intruderThreads)
+	v.diff(now, cur)
+	v.prev = cur
+
+	var b strings.Builder
+	v.renderHeader(&b, out, interval)
+	v.renderCPUs(&b, osCPUs, gameCPUs)
+	v.renderSlices(&b, out)
+	v.renderGames(&b, out, games)
+	v.renderIntruders(&b, intruders, intruderThreads)
+	v.renderEvents(&b)
+
+	if v.tty {
+		frame := strings.ReplaceAll(b.String(), "\n", ansiClearLine+"\n")
+		fmt.Fprint(w, ansiHome+frame+ansiClearBelow)
+		return
+	}
+	fmt.Fprintln(w, b.String())
+}
+
+// watchGames samples the threads of every game process and collects those
+// currently on a CPU outside GAME CPUs.
+func watchGames(out statusOutput, gameCPUs []int) []*watchGame {
+	var games []*watchGame
+	byID := map[string]*watchGame{}
+	for _, p := range out.Games {
+		g := byID[p.GameID]
+		if g == nil {
+			g = &watchGame{id: p.GameID, mode: p.Mode}
+			if p.InScope {
+				g.unit = unitFromCgroup(p.Cgroup)
+			}
+			byID[p.GameID] = g
+			games = append(games, g)
+		}
+		g.pids = append(g.pids, p.PID)
+		threads, err := procscan.ThreadSchedStats(p.PID)
+		if err != nil {
+			continue
+		}
+		g.threads += len(threads)
+		if len(gameCPUs) == 0 {
+			continue
+		}
+		for _, ts := range threads {
+			if !topology.ContainsCPU(gameCPUs, ts.Processor) {
+				g.offGame = append(g.offGame, threadPlacement{pid: p.PID, tid: ts.TID, comm: ts.Comm, cpu: ts.Processor})
+			}
+		}
+	}
+	return games
+}
+
+// unitFromCgroup returns the last .scope component of a cgroup path.
+func unitFromCgroup(cgroup string) string {
+	parts := strings.Split(cgroup, "/")
+	for i := len(parts) - 1; i >= 0; i-- {
+		if strings.HasSuffix(parts[i], ".scope") {
+			return parts[i]
+		}
+	}
+	return ""
+}
+
+// watchIntruders finds threads of the user's non-game processes that are
+// currently running on GAME CPUs. That is expected while no game runs, so
+// the caller only highlights it when slices are pinned.
+func watchIntruders(out statusOutput, gameCPUs []int) ([]intruder, int) {
+	if len(gameCPUs) == 0 {
+		return nil, 0
+	}
+	gamePIDs := make(map[int]struct{}, len(out.Games))
+	for _, p := range out.Games {
+		gamePIDs[p.PID] = struct{}{}
+	}
+	procs, err := procscan.ScanUserCPUConstraints(os.Getuid())
+	if err != nil {
+		return nil, 0
+	}
+	self := os.Getpid()
+	byExe := map[string]*intruder{}
+	total := 0
+	for _, p := range procs {
+		if _, ok := gamePIDs[p.PID]; ok || p.PID == self {
+			continue
+		}
+		threads, err := procscan.ThreadSchedStats(p.PID)
+		if err != nil {
+			continue
+		}
+		for _, ts := range threads {
+			if !topology.ContainsCPU(gameCPUs, ts.Processor) {
+				continue
+			}
+			in := byExe[p.Exe]
+			if in == nil {
+				in = &intruder{exe: p.Exe}
+				byExe[p.Exe] = in
+			}
+			in.threads++
+			if !topology.ContainsCPU(in.cpus, ts.Processor) {
+				in.cpus = append(in.cpus, ts.Processor)
+			}
+			total++
+		}
+	}
+	list := make([]intruder, 0, len(byExe))
+	for _, in := range byExe {
+		sort.Ints(in.cpus)
+		list = append(list, *in)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].threads != list[j].threads {
+			return list[i].threads > list[j].threads
+		}
+		return list[i].exe < list[j].exe
+	})
+	return list, total
+}
+
+func newWatchSnapshot(out statusOutput, games []*watchGame, intruders int) *watchSnapshot {
+	s := &watchSnapshot{
+		pinApplied: out.State.PinApplied,
+		paused:     out.State.Overrides.IsPaused(out.GeneratedAt),
+		games:      map[string]int{},
+		slices:     map[string]string{},
+		scopes:     map[string]string{},
+		offGame:    map[string]int{},
+		intruders:  intruders,
+		errors:     map[string]struct{}{},
+	}
+	if out.Daemon != nil {
+		s.daemonPID = out.Daemon.PID
+		s.paused = out.Daemon.Paused
+	}
+	for _, g := range games {
+		s.games[g.id] = len(g.pids)
+		s.offGame[g.id] = len(g.offGame)
+	}
+	for _, sl := range out.Slices {
+		if sl.ReadAllowedCPUErr == "" {
+			s.slices[sl.Unit] = sl.AllowedCPUs
+		}
+	}
+	for _, sc := range out.Scopes {
+		s.scopes[sc.Unit] = sc.ActiveState + "/" + sc.SubState
+	}
+	for _, e := range out.Errors {
+		s.errors[e] = struct{}{}
+	}
+	return s
+}
+
+// diff records an event for every change between the previous snapshot and
+// cur.
+func (v *watchView) diff(now time.Time, cur *watchSnapshot) {
+	prev := v.prev
+	if prev == nil {
+		prev = &watchSnapshot{}
+		if cur.daemonPID == 0 {
+			v.event(now, "daemon not running")
+		}
+	}
+	switch {
+	case cur.daemonPID != 0 && prev.daemonPID == 0:
+		v.event(now, fmt.Sprintf("daemon up (pid %d)", cur.daemonPID))
+	case cur.daemonPID == 0 && prev.daemonPID != 0:
+		v.event(now, "daemon down")
+	case cur.daemonPID != prev.daemonPID:
+		v.event(now, fmt.Sprintf("daemon restarted (pid %d)", cur.daemonPID))
+	}
+	if cur.pinApplied != prev.pinApplied {
+		if cur.pinApplied {
+			v.event(now, "slices pinned to OS CPUs")
+		} else if v.prev != nil {
+			v.event(now, "slices restored")
+		}
+	}
+	if cur.paused != prev.paused {
+		if cur.paused {
+			v.event(now, "paused")
+		} else if v.prev != nil {
+			v.event(now, "resumed")
+		}
+	}
+
+	for _, id := range sortedKeys(cur.games) {
+		if _, ok := prev.games[id]; !ok {
+			v.event(now, fmt.Sprintf("game %s appeared (%d procs)", id, cur.games[id]))
+		}
+		if n, was := cur.offGame[id], prev.offGame[id]; n > 0 && was == 0 {
+			v.event(now, fmt.Sprintf("game %s: %d threads off GAME CPUs", id, n))
+		} else if n == 0 && was > 0 {
+			v.event(now, fmt.Sprintf("game %s: all threads back on GAME CPUs", id))
+		}
+	}
+	for _, id := range sortedKeys(prev.games) {
+		if _, ok := cur.games[id]; !ok {
+			v.event(now, fmt.Sprintf("game %s gone", id))
+		}
+	}
+
+	for _, unit := range sortedKeys(cur.slices) {
+		if old, ok := prev.slices[unit]; ok && old != cur.slices[unit] {
+			v.event(now, fmt.Sprintf("%s AllowedCPUs %s -> %s", unit, orAll(old), orAll(cur.slices[unit])))
+		}
+	}
+
+	for _, unit := range sortedKeys(cur.scopes) {
+		old, ok := prev.scopes[unit]
+		switch {
+		case !ok:
+			v.event(now, fmt.Sprintf("%s %s", unit, cur.scopes[unit]))
+		case old != cur.scopes[unit]:
+			v.event(now, fmt.Sprintf("%s %s -> %s", unit, old, cur.scopes[unit]))
+		}
+	}
+	for _, unit := range sortedKeys(prev.scopes) {
+		if _, ok := cur.scopes[unit]; !ok {
+			v.event(now, unit+" removed")
+		}
+	}
+
+	if cur.pinApplied && cur.intruders > 0 && (prev.intruders == 0 || !prev.pinApplied) {
+		v.event(now, fmt.Sprintf("%d non-game threads on GAME CPUs", cur.intruders))
+	}
+
+	for _, e := range sortedKeys(cur.errors) {
+		if _, ok := prev.errors[e]; !ok {
+			v.event(now, "error: "+e)
+		}
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func orAll(cpus string) string {
+	if cpus == "" {
+		return "(all)"
+	}
+	return cpus
+}
+
+func (v *watchView) renderHeader(b *strings.Builder, out statusOutput, interval time.Duration) {
+	fmt.Fprintf(b, "%s  %s  every %s  (Ctrl-C to quit)\n", v.paint("ccdbind status --watch", ansiBold), out.GeneratedAt.Format("15:04:05"), interval)
+	daemon := v.paint("daemon: not running", ansiYellow)
+	if d := out.Daemon; d != nil {
+		daemon = fmt.Sprintf("daemon: pid=%d up %s backend=%s", d.PID, out.GeneratedAt.Sub(d.StartedAt).Round(time.Second), d.Backend)
+		if d.DryRun {
+			daemon += " dry-run"
+		}
+	}
+	pin := "pin: restored"
+	if out.State.PinApplied {
+		pin = v.paint("pin: applied", ansiGreen)
+	}
+	paused := out.State.Overrides.IsPaused(out.GeneratedAt)
+	if out.Daemon != nil {
+		paused = out.Daemon.Paused
+	}
+	line := daemon + "  " + pin
+	if paused {
+		line += "  " + v.paint("PAUSED", ansiYellow)
+	}
+	fmt.Fprintln(b, line)
+	b.WriteString("\n")
+}
+
+func (v *watchView) renderCPUs(b *strings.Builder, osCPUs, gameCPUs []int) {
+	cur, err := procscan.CPUTimes()
+	if err != nil {
+		fmt.Fprintf(b, "cpu: %v\n\n", err)
+		return
+	}
+	prev := v.prevCPU
+	v.prevCPU = cur
+
+	usage := func(cpu int) (float64, bool) {
+		c, ok := cur[cpu]
+		p, okPrev := prev[cpu]
+		if !ok || !okPrev || c.Total <= p.Total {
+			return 0, false
+		}
+		return 100 * float64(c.Busy-p.Busy) / float64(c.Total-p.Total), true
+	}
+
+	var other []int
+	for cpu := range cur {
+		if !topology.ContainsCPU(osCPUs, cpu) && !topology.ContainsCPU(gameCPUs, cpu) {
+			other = append(other, cpu)
+		}
+	}
+	sort.Ints(other)
+
+	groups := []struct {
+		name string
+		cpus []int
+		code string
+	}{
+		{"OS", osCPUs, ansiCyan},
+		{"GAME", gameCPUs, ansiGreen},
+		{"OTHER", other, ansiDim},
+	}
+	for _, g := range groups {
+		if len(g.cpus) == 0 {
+			continue
+		}
+		var sum float64
+		n := 0
+		for _, cpu := range g.cpus {
+			if u, ok := usage(cpu); ok {
+				sum += u
+				n++
+			}
+		}
+		avg := "  --"
+		if n > 0 {
+			avg = fmt.Sprintf("%3.0f%%", sum/float64(n))
+		}
+		fmt.Fprintf(b, "%s %s avg %s\n", v.paint(fmt.Sprintf("%-5s", g.name), ansiBold+g.code), topology.FormatCPUList(g.cpus), avg)
+		for i, cpu := range g.cpus {
+			u, ok := usage(cpu)
+			cell := fmt.Sprintf("%3d [%s]  --", cpu, strings.Repeat(" ", watchBarWidth))
+			if ok {
+				fill := int(u/100*watchBarWidth + 0.5)
+				fill = min(max(fill, 0), watchBarWidth)
+				bar := v.paint(strings.Repeat("#", fill), g.code) + strings.Repeat(" ", watchBarWidth-fill)
+				cell = fmt.Sprintf("%3d [%s]%3.0f%%", cpu, bar, u)
+			}
+			b.WriteString(" " + cell)
+			if (i+1)%watchCPUsPerRow == 0 || i == len(g.cpus)-1 {
+				b.WriteString("\n")
+			}
+		}
+	}
+	b.WriteString("\n")
+}
+
+func (v *watchView) renderSlices(b *strings.Builder, out statusOutput) {
+	if len(out.Slices) == 0 {
+		return
+	}
+	b.WriteString(v.paint("slices", ansiBold) + "\n")
+	for _, s := range out.Slices {
+		if s.ReadAllowedCPUErr != "" {
+			fmt.Fprintf(b, "  %-20s %s\n", s.Unit, v.paint("error: "+s.ReadAllowedCPUErr, ansiRed))
+			continue
+		}
+		allowed := orAll(s.AllowedCPUs)
+		if out.State.PinApplied && s.AllowedCPUs == out.OSCPUs {
+			allowed = v.paint(allowed, ansiCyan)
+		}
+		line := fmt.Sprintf("  %-20s AllowedCPUs=%s", s.Unit, allowed)
+		if s.OriginalAllowed != "" || out.State.PinApplied {
+			line += fmt.Sprintf("  original=%s", orAll(s.OriginalAllowed))
+		}
+		b.WriteString(line + "\n")
+	}
+	b.WriteString("\n")
+}
+
+func (v *watchView) renderGames(b *strings.Builder, out statusOutput, games []*watchGame) {
+	b.WriteString(v.paint("games", ansiBold) + "\n")
+	if len(games) == 0 {
+		b.WriteString("  none\n")
+	}
+	for _, g := range games {
+		unit := g.unit
+		if unit == "" {
+			unit = "-"
+		}
+		line := fmt.Sprintf("  %s mode=%s scope=%s pids=%v threads=%d", v.paint(g.id, ansiGreen), g.mode, unit, g.pids, g.threads)
+		if len(g.offGame) > 0 {
+			line += "  " + v.paint(fmt.Sprintf("%d off GAME CPUs", len(g.offGame)), ansiRed)
+		}
+		b.WriteString(line + "\n")
+		for i, t := range g.offGame {
+			if i == watchMaxThreadLines {
+				fmt.Fprintf(b, "    ... %d more\n", len(g.offGame)-i)
+				break
+			}
+			fmt.Fprintf(b, "    %s\n", v.paint(fmt.Sprintf("pid=%d tid=%d %s on cpu %d", t.pid, t.tid, t.comm, t.cpu), ansiRed))
+		}
+	}
+	var orphaned []string
+	for _, sc := range out.Scopes {
+		if sc.Orphaned {
+			orphaned = append(orphaned, sc.Unit)
+		}
+	}
+	if len(orphaned) > 0 {
+		fmt.Fprintf(b, "  %s\n", v.paint("orphaned scopes: "+strings.Join(orphaned, " "), ansiYellow))
+	}
+	b.WriteString("\n")
+}
+
+func (v *watchView) renderIntruders(b *strings.Builder, list []intruder, total int) {
+	if total == 0 {
+		return
+	}
+	code := ansiDim
+	title := fmt.Sprintf("other threads on GAME CPUs: %d", total)
+	if v.prev != nil && v.prev.pinApplied {
+		code = ansiRed
+	} else {
+		title += " (slices not pinned)"
+	}
+	b.WriteString(v.paint(title, ansiBold) + "\n")
+	for i, in := range list {
+		if i == watchMaxThreadLines {
+			fmt.Fprintf(b, "  ... %d more programs\n", len(list)-i)
+			break
+		}
+		fmt.Fprintf(b, "  %s\n", v.paint(fmt.Sprintf("%s threads=%d cpus=%s", in.exe, in.threads, topology.FormatCPUList(in.cpus)), code))
+	}
+	b.WriteString("\n")
+}
+
+func (v *watchView) renderEvents(b *strings.Builder) {
+	b.WriteString(v.paint("events", ansiBold) + "\n")
+	for i := len(v.events) - 1; i >= 0; i-- {
+		e := v.events[i]
+		fmt.Fprintf(b, "  %s %s\n", v.paint(e.at.Format("15:04:05"), ansiDim), e.msg)
+	}
+}
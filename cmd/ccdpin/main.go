@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -10,14 +9,17 @@ import (
 	"log"
 	"os"
 	"os/exec"
-	"os/signal"
 	"path/filepath"
 	"runtime/debug"
-	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/Reidond/ccdbind/internal/config"
+	"github.com/Reidond/ccdbind/internal/control"
+	"github.com/Reidond/ccdbind/internal/pinstate"
+	"github.com/Reidond/ccdbind/internal/procscan"
 	"github.com/Reidond/ccdbind/internal/systemdctl"
 	"github.com/Reidond/ccdbind/internal/topology"
 )
@@ -30,20 +32,44 @@ const (
 	envNoScope  = "STEAM_CCD_NO_SCOPE"
 	envOSSlices = "STEAM_CCD_OS_SLICES"
 	envDebug    = "STEAM_CCD_DEBUG"
+	envGrace    = "STEAM_CCD_GRACE"
+
+	envNoSubreaper = "STEAM_CCD_NO_SUBREAPER"
 )
 
 // logFile is the global log file handle for crash logging.
 var logFile *os.File
 
+// releaseSlices undoes the OS-slice pin. fatal and recoverPanic call it too,
+// so the slices are restored on every exit path that ccdpin controls.
+var releaseSlices = func() {}
+
 type options struct {
 	print bool
 	swap  bool
 
-	noOSPin bool
-	noScope bool
+	// showState, prune and restore run a maintenance action on the shared
+	// pin state instead of launching a game.
+	showState bool
+	prune     bool
+	restore   bool
+
+	// dryRun and explain describe the launch instead of performing it.
+	dryRun  bool
+	explain bool
+
+	noOSPin     bool
+	noScope     bool
+	noSubreaper bool
 
 	gameCPUs string
 	osCPUs   string
+
+	// grace is how long the game may take to exit after a terminating
+	// signal; negative means unset.
+	grace time.Duration
+
+	configPath string
 }
 
 type resolved struct {
@@ -51,10 +77,23 @@ type resolved struct {
 	gameCPUs string
 	ccds     []string
 
+	swap     bool
 	noOSPin  bool
 	noScope  bool
 	osSlices []string
-	debug    bool
+	// noSubreaper returns as soon as the direct child exits instead of
+	// waiting for every descendant.
+	noSubreaper bool
+	debug       bool
+	grace       time.Duration
+
+	// gameID is taken from the environment using the config's env_keys;
+	// profile is set when the config has a [games."<gameID>"] table.
+	gameID  string
+	profile *config.GameProfile
+
+	// from maps each setting name to where its value came from.
+	from map[string]string
 }
 
 func main() {
@@ -68,13 +107,36 @@ func main() {
 		fatal(err)
 	}
 
-	r, err := resolve(opts)
+	if opts.showState || opts.prune || opts.restore {
+		if err := runMaintenance(opts, os.Stdout); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	cfg, err := loadConfig(opts.configPath)
+	if err != nil {
+		warnf("config: %v; using defaults", err)
+	}
+
+	r, err := resolve(opts, cfg, systemSources())
 	if err != nil {
 		fatal(err)
 	}
 
-	if opts.print {
-		printTopology(r)
+	if opts.print || opts.explain || opts.dryRun {
+		if opts.print {
+			printTopology(os.Stdout, r)
+		}
+		if opts.explain {
+			printExplain(os.Stdout, r)
+		}
+		if opts.dryRun {
+			if len(cmd) == 0 {
+				fatal(errors.New("no command provided"))
+			}
+			printPlan(os.Stdout, r, cmd, systemdctl.Systemctl{DryRun: true})
+		}
 		return
 	}
 	if len(cmd) == 0 {
@@ -84,38 +146,24 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	sigc := make(chan os.Signal, 2)
-	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		<-sigc
-		cancel()
-	}()
+	fwd := newForwarder(r.grace, cancel)
+	fwd.listen()
 
-	logInfo("game_cpus=%s os_cpus=%s no_os_pin=%v", r.gameCPUs, r.osCPUs, r.noOSPin)
+	gameID := r.gameID
+	logInfo("game_cpus=%s os_cpus=%s no_os_pin=%v game_id=%q profile=%v", r.gameCPUs, r.osCPUs, r.noOSPin, gameID, r.profile != nil)
 	logInfo("command: %v", cmd)
 
 	sys := systemdctl.Systemctl{}
-	cleanup := func() {}
 	if !r.noOSPin {
-		pin, err := newSlicePinManager(sys, r.osSlices, r.osCPUs, r.debug)
-		if err != nil {
-			warnf("os slice pin disabled: %v", err)
-		} else {
-			c, err := pin.AcquireAndPin(ctx)
-			if err != nil {
-				warnf("failed to pin OS slices: %v", err)
-			} else {
-				cleanup = c
-			}
-		}
+		releaseSlices = sync.OnceFunc(holdOSSlices(ctx, sys, r, gameID))
 	}
 
 	startTime := time.Now()
 	logInfo("launching game...")
-	exitCode := runGame(ctx, sys, r.gameCPUs, cmd, r.debug, r.noScope)
+	exitCode := runGame(ctx, fwd, r, cmd)
 	duration := time.Since(startTime)
 	logInfo("game exited with code %d after %v", exitCode, duration)
-	cleanup()
+	releaseSlices()
 	os.Exit(exitCode)
 }
 
@@ -124,11 +172,19 @@ func parseArgs(args []string, out io.Writer, errOut io.Writer) (options, []strin
 	fs.SetOutput(errOut)
 	var opts options
 	fs.BoolVar(&opts.print, "print", false, "print detected topology and selected CPU sets")
+	fs.BoolVar(&opts.showState, "state", false, "print the shared OS-slice pin state and its ccdpin instances, then exit")
+	fs.BoolVar(&opts.prune, "prune", false, "drop dead ccdpin instances and restore the slices if none is left, then exit")
+	fs.BoolVar(&opts.restore, "restore", false, "restore the slices from the saved originals even if ccdpin instances are running, then exit")
+	fs.BoolVar(&opts.dryRun, "dry-run", false, "print the scope, command and slice changes a launch would make, then exit")
+	fs.BoolVar(&opts.explain, "explain", false, "print where each setting came from (flag, env, profile, config, detection), then exit")
 	fs.BoolVar(&opts.swap, "swap", false, "swap OS and GAME CPU assignments")
 	fs.BoolVar(&opts.noOSPin, "no-os-pin", false, "do not pin OS slices")
-	fs.BoolVar(&opts.noScope, "no-scope", false, "skip systemd-run scope (use taskset only, for anti-cheat games)")
+	fs.BoolVar(&opts.noScope, "no-scope", false, "skip the game scope and only set CPU affinity (for anti-cheat games)")
+	fs.BoolVar(&opts.noSubreaper, "no-subreaper", false, "return when the direct child exits instead of waiting for all of its descendants")
 	fs.StringVar(&opts.gameCPUs, "game-cpus", "", "override GAME CPU list")
 	fs.StringVar(&opts.osCPUs, "os-cpus", "", "override OS CPU list")
+	fs.StringVar(&opts.configPath, "config", "", "ccdbind config file (TOML). Default: XDG config path")
+	fs.DurationVar(&opts.grace, "grace", -1, "time the game gets to exit after SIGINT/SIGTERM/SIGHUP/SIGQUIT before it is killed; 0 waits forever (default 10s)")
 	fs.Usage = func() {
 		fmt.Fprintln(out, "usage: ccdpin [flags] [--] COMMAND [args...]")
 		fmt.Fprintln(out, "")
@@ -136,218 +192,241 @@ func parseArgs(args []string, out io.Writer, errOut io.Writer) (options, []strin
 		fs.PrintDefaults()
 		fmt.Fprintln(out, "")
 		fmt.Fprintln(out, "environment overrides (compat):")
-		fmt.Fprintf(out, "  %s, %s, %s, %s, %s, %s, %s\n", envGameCPUs, envOSCPUs, envSwap, envNoOSPin, envNoScope, envOSSlices, envDebug)
+		fmt.Fprintf(out, "  %s, %s, %s, %s, %s, %s, %s, %s, %s\n", envGameCPUs, envOSCPUs, envSwap, envNoOSPin, envNoScope, envOSSlices, envDebug, envGrace, envNoSubreaper)
+		fmt.Fprintln(out, "")
+		fmt.Fprintln(out, "precedence: flags > environment > [games.\"<SteamAppId>\"] profile > global config > detection")
 	}
 
-	if err := fs.Parse(args); err != nil {
+	flags, cmd := splitArgs(fs, args)
+	if err := fs.Parse(flags); err != nil {
 		return options{}, nil, err
 	}
-	return opts, fs.Args(), nil
-}
-
-func resolve(opts options) (resolved, error) {
-	debug := parseBoolEnv(envDebug)
-	noOSPin := opts.noOSPin || parseBoolEnv(envNoOSPin)
-	noScope := opts.noScope || parseBoolEnv(envNoScope)
-	swap := opts.swap || parseBoolEnv(envSwap)
-
-	osSlices := parseSlicesEnv(os.Getenv(envOSSlices))
-	if len(osSlices) == 0 {
-		osSlices = []string{"app.slice", "background.slice", "session.slice"}
-	}
-
-	osCPUs := strings.TrimSpace(opts.osCPUs)
-	if osCPUs == "" {
-		osCPUs = strings.TrimSpace(os.Getenv(envOSCPUs))
-	}
-	gameCPUs := strings.TrimSpace(opts.gameCPUs)
-	if gameCPUs == "" {
-		gameCPUs = strings.TrimSpace(os.Getenv(envGameCPUs))
-	}
-
-	// Match the script behavior:
-	// - If both OS+GAME are provided explicitly, use them.
-	// - Otherwise auto-detect and fill missing.
-	var det topology.Result
-	needDetect := opts.print || osCPUs == "" || gameCPUs == "" || swap
-	if needDetect {
-		res, err := topology.Detect()
-		if err != nil {
-			return resolved{}, err
+	n := 0
+	for _, set := range []bool{opts.print || opts.explain || opts.dryRun, opts.showState, opts.prune, opts.restore} {
+		if set {
+			n++
 		}
-		det = res
-	}
-	if osCPUs == "" {
-		osCPUs = det.OSCPUs
-	}
-	if gameCPUs == "" {
-		gameCPUs = det.GameCPUs
 	}
-	if strings.TrimSpace(gameCPUs) == "" {
-		return resolved{}, fmt.Errorf("could not resolve GAME_CPUS")
+	if n > 1 {
+		return options{}, nil, errors.New("--state, --prune and --restore cannot be combined with each other or with --print, --explain or --dry-run")
 	}
+	return opts, cmd, nil
+}
 
-	var err error
-	if strings.TrimSpace(osCPUs) != "" {
-		osCPUs, _, err = topology.CanonicalizeCPUList(osCPUs)
-		if err != nil {
-			return resolved{}, fmt.Errorf("invalid OS CPU list %q: %w", osCPUs, err)
+// splitArgs separates ccdpin's flags from the command. Steam's %command%
+// may itself begin with "-", so the command starts at "--" or at the first
+// argument that is not one of ccdpin's flags, rather than wherever the flag
+// package would stop.
+func splitArgs(fs *flag.FlagSet, args []string) (flags []string, cmd []string) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return flags, args[i+1:]
 		}
-	}
-	gameCPUs, _, err = topology.CanonicalizeCPUList(gameCPUs)
-	if err != nil {
-		return resolved{}, fmt.Errorf("invalid GAME CPU list %q: %w", gameCPUs, err)
-	}
-
-	if swap {
-		if strings.TrimSpace(osCPUs) == "" {
-			return resolved{}, fmt.Errorf("cannot swap without OS_CPUS")
+		name, hasValue := flagName(arg)
+		if name == "h" || name == "help" {
+			flags = append(flags, arg)
+			continue
+		}
+		f := fs.Lookup(name)
+		if name == "" || f == nil {
+			return flags, args[i:]
+		}
+		flags = append(flags, arg)
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			continue
+		}
+		if !hasValue && i+1 < len(args) {
+			i++
+			flags = append(flags, args[i])
 		}
-		osCPUs, gameCPUs = gameCPUs, osCPUs
 	}
-
-	return resolved{osCPUs: osCPUs, gameCPUs: gameCPUs, ccds: det.Lists, noOSPin: noOSPin, noScope: noScope, osSlices: osSlices, debug: debug}, nil
+	return flags, nil
 }
 
-func printTopology(r resolved) {
-	if len(r.ccds) > 0 {
-		fmt.Println("Detected CCD CPU groups:")
-		for i, s := range r.ccds {
-			fmt.Printf("  CCD[%d] = %s\n", i, strings.TrimSpace(s))
-		}
-		fmt.Println("")
+// flagName returns the name of a "-name", "--name" or "--name=value"
+// argument, or "" if arg is not flag-shaped.
+func flagName(arg string) (name string, hasValue bool) {
+	if len(arg) < 2 || arg[0] != '-' {
+		return "", false
 	}
-	fmt.Println("Selected:")
-	if r.osCPUs != "" {
-		fmt.Printf("  OS_CPUS   = %s\n", r.osCPUs)
+	name = strings.TrimPrefix(arg[1:], "-")
+	if name == "" || name[0] == '-' || name[0] == '=' {
+		return "", false
 	}
-	fmt.Printf("  GAME_CPUS = %s\n", r.gameCPUs)
-	if len(r.osSlices) > 0 {
-		fmt.Printf("  OS_SLICES = %s\n", strings.Join(r.osSlices, " "))
+	if i := strings.IndexByte(name, '='); i >= 0 {
+		return name[:i], true
 	}
+	return name, false
 }
 
-func parseSlicesEnv(v string) []string {
-	v = strings.TrimSpace(v)
-	if v == "" {
-		return nil
-	}
-	fields := strings.Fields(v)
-	out := make([]string, 0, len(fields))
-	seen := map[string]struct{}{}
-	for _, f := range fields {
-		f = strings.TrimSpace(f)
-		if f == "" {
-			continue
-		}
-		if !strings.HasSuffix(f, ".slice") {
-			continue
+// holdOSSlices keeps the OS slices pinned while the game runs and returns
+// the release function. Only one component owns the slices' originals at a
+// time: ccdpin joins a pin other ccdpin instances already hold, otherwise
+// hands the pin to a running ccdbind daemon, and only pins the slices itself
+// when neither is around.
+func holdOSSlices(ctx context.Context, sys systemdctl.Systemctl, r resolved, gameID string) func() {
+	pin, err := newSlicePinManager(sys, r.osSlices, r.osCPUs, r.debug)
+	if err != nil {
+		warnf("os slice pin disabled: %v", err)
+		return func() {}
+	}
+	if !pin.held() {
+		release, err := registerWithDaemon(gameID)
+		if err == nil {
+			logInfo("os slice pin handed to the ccdbind daemon")
+			debugf(r.debug, "registered with ccdbind daemon; it pins the OS slices")
+			return release
 		}
-		if _, ok := seen[f]; ok {
-			continue
+		if !errors.Is(err, control.ErrNotRunning) {
+			debugf(r.debug, "ccdbind daemon unavailable (%v); pinning OS slices directly", err)
 		}
-		seen[f] = struct{}{}
-		out = append(out, f)
 	}
-	return out
+	release, err := pin.AcquireAndPin(ctx)
+	if err != nil {
+		warnf("failed to pin OS slices: %v", err)
+		return func() {}
+	}
+	return release
 }
 
-func parseBoolEnv(k string) bool {
-	v := strings.TrimSpace(os.Getenv(k))
-	if v == "" {
-		return false
+// registerWithDaemon registers this process as a launcher with a running
+// ccdbind. The daemon keeps the slices pinned until the returned function
+// unregisters it, or this process exits.
+func registerWithDaemon(gameID string) (func(), error) {
+	c, err := control.Dial()
+	if err != nil {
+		return nil, err
 	}
-	switch strings.ToLower(v) {
-	case "1", "true", "yes", "y", "on", "enable", "enabled":
-		return true
-	case "0", "false", "no", "n", "off", "disable", "disabled":
-		return false
-	default:
-		return true
+	ctx, cancel := systemdctl.DefaultContext()
+	defer cancel()
+	if err := c.RegisterLauncher(ctx, os.Getpid(), gameID); err != nil {
+		c.Close()
+		return nil, err
 	}
+	return func() {
+		defer c.Close()
+		ctx, cancel := systemdctl.DefaultContext()
+		defer cancel()
+		if err := c.UnregisterLauncher(ctx, os.Getpid()); err != nil {
+			logInfo("unregister from daemon: %v", err)
+		}
+	}, nil
 }
 
-func runGame(ctx context.Context, sys systemdctl.Systemctl, gameCPUs string, cmd []string, debug bool, noScope bool) int {
-	userSystemd := userSystemdAvailable(ctx)
-	if userSystemd && !noScope {
-		ctx2, cancel := systemdctl.DefaultContext()
-		_ = sys.StartUnit(ctx2, "game.slice")
-		cancel()
+func runGame(ctx context.Context, fwd *forwarder, r resolved, cmd []string) int {
+	// Launchers such as Steam's reaper may exit before the game, or leave
+	// it orphaned; as a subreaper ccdpin inherits those orphans and can
+	// hold the pin until they are gone too.
+	tree := !r.noSubreaper
+	if tree {
+		if err := setChildSubreaper(); err != nil {
+			warnf("subreaper: %v; only waiting for the direct child", err)
+			tree = false
+		}
 	}
 
-	if userSystemd && hasBinary("systemd-run") && !noScope {
-		args := []string{
-			"--user",
-			"--scope",
-			"--quiet",
-			"--slice=game.slice",
-			"-p", "AllowedCPUs=" + gameCPUs,
+	if !r.noScope {
+		unit, err := enterGameScope(ctx, r.gameCPUs, r.gameID)
+		if err == nil {
+			logInfo("running in %s (AllowedCPUs=%s)", unit, r.gameCPUs)
+			return runCmd(fwd, cmd[0], cmd[1:], r.debug, tree)
 		}
-		args = append(args, systemdRunSetenvArgs()...)
-		args = append(args, "--")
-		if hasBinary("taskset") {
-			args = append(args, "taskset", "-c", gameCPUs)
-			args = append(args, cmd...)
-			return runCmd(ctx, "systemd-run", args, debug)
-		}
-		args = append(args, cmd...)
-		return runCmd(ctx, "systemd-run", args, debug)
+		warnf("game scope: %v; falling back to CPU affinity", err)
 	}
 
-	if hasBinary("taskset") {
-		args := append([]string{"-c", gameCPUs}, cmd...)
-		return runCmd(ctx, "taskset", args, debug)
+	// Without a scope, pin ccdpin itself; the game inherits the mask. This
+	// is not done in a scope, where a sticky mask would outlive the daemon
+	// unpinning the scope.
+	if err := pinSelf(r.gameCPUs); err != nil {
+		warnf("pin to GAME CPUs %s: %v", r.gameCPUs, err)
+		return 1
 	}
+	logInfo("running with affinity %s", r.gameCPUs)
+	return runCmd(fwd, cmd[0], cmd[1:], r.debug, tree)
+}
 
-	warnf("neither systemd-run nor taskset available; running without pin")
-	return runCmd(ctx, cmd[0], cmd[1:], debug)
+// pinSelf sets the affinity of every ccdpin thread to cpus, so whichever
+// thread forks the game passes the mask on.
+func pinSelf(cpus string) error {
+	list, err := topology.ParseCPUList(cpus)
+	if err != nil {
+		return err
+	}
+	return procscan.SetProcessAffinity(os.Getpid(), list)
 }
 
-func systemdRunSetenvArgs() []string {
-	// Ensure the launched scope sees the same environment as this process.
-	// This matters for Steam/Proton usage (e.g. PROTON_* variables).
-	env := os.Environ()
-	out := make([]string, 0, len(env))
-	seen := map[string]struct{}{}
-	for _, kv := range env {
-		if kv == "" {
-			continue
-		}
-		i := strings.IndexByte(kv, '=')
-		if i <= 0 {
-			continue
-		}
-		k := kv[:i]
-		if k == "" {
-			continue
-		}
-		if _, ok := seen[k]; ok {
-			continue
-		}
-		seen[k] = struct{}{}
-		out = append(out, "--setenv="+kv)
+// gameScopeName is the scope ccdpin runs a game in: the daemon's name for
+// the game, or one named after ccdpin's PID when there is no game ID so it
+// never matches the daemon's game-* scopes.
+func gameScopeName(gameID string, pid int) string {
+	if gameID == "" {
+		return fmt.Sprintf("ccdpin-%d.scope", pid)
 	}
-	return out
+	return systemdctl.UnitNameForGameID(gameID)
 }
 
-func userSystemdAvailable(ctx context.Context) bool {
-	if !hasBinary("systemctl") {
-		return false
+// enterGameScope moves ccdpin itself into a transient scope under game.slice
+// and restricts the scope to gameCPUs. The game is then started as a normal
+// child, so it inherits the cgroup and the environment unchanged.
+//
+// The scope is named the way ccdbind names it, so the daemon adopts it
+// instead of creating a second one. If it already exists, e.g. another
+// launch of the same game, ccdpin joins it.
+func enterGameScope(ctx context.Context, gameCPUs string, gameID string) (string, error) {
+	mgr, err := systemdctl.NewUserManager(false)
+	if err != nil {
+		return "", err
 	}
-	ctx, cancel := context.WithTimeout(ctx, 1*time.Second)
+	defer mgr.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
-	cmd := exec.CommandContext(ctx, "systemctl", "--user", "show", "-p", "Version", "--value")
-	cmd.Stdout = io.Discard
-	cmd.Stderr = io.Discard
-	return cmd.Run() == nil
+
+	if err := mgr.StartUnit(ctx, "game.slice"); err != nil {
+		logInfo("start game.slice: %v", err)
+	}
+
+	pid := os.Getpid()
+	unit := gameScopeName(gameID, pid)
+	desc := "ccdpin game"
+	if gameID != "" {
+		desc = "ccdpin game " + gameID
+	}
+	created, err := mgr.EnsureTransientScope(ctx, unit, []int{pid}, "game.slice", desc)
+	if err != nil {
+		return "", fmt.Errorf("create %s: %w", unit, err)
+	}
+	if !created {
+		if err := mgr.AttachProcessesToUnit(ctx, unit, "", []int{pid}); err != nil {
+			return "", fmt.Errorf("attach to %s: %w", unit, err)
+		}
+	}
+	if err := mgr.SetUnitAllowedCPUs(ctx, unit, gameCPUs); err != nil {
+		return "", fmt.Errorf("set AllowedCPUs on %s: %w", unit, err)
+	}
+	return unit, nil
 }
 
-func runCmd(ctx context.Context, bin string, args []string, debug bool) int {
+// runCmd runs the game in its own process group, relaying ccdpin's signals
+// to it through fwd, and returns its exit code. With tree set it also waits
+// for every orphaned descendant before returning.
+func runCmd(fwd *forwarder, bin string, args []string, debug bool, tree bool) int {
+	if sig := fwd.interrupted(); sig != nil {
+		return 128 + int(sig.(syscall.Signal))
+	}
 	fullCmd := bin + " " + strings.Join(args, " ")
 	logInfo("exec: %s", fullCmd)
 	debugf(debug, "exec: %s", fullCmd)
-	c := exec.CommandContext(ctx, bin, args...)
+	c := exec.Command(bin, args...)
 	c.Stdin = os.Stdin
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	// When ccdpin owns the terminal, hand the foreground to the game so it
+	// keeps reading input and gets Ctrl-C and Ctrl-Z directly.
+	if fd := int(os.Stdin.Fd()); inForeground(fd) {
+		c.SysProcAttr.Foreground = true
+		c.SysProcAttr.Ctty = fd
+	}
 
 	// In debug mode, capture stdout/stderr to log file as well
 	if debug && logFile != nil {
@@ -377,7 +456,20 @@ func runCmd(ctx context.Context, bin string, args []string, debug bool) int {
 		c.Stderr = os.Stderr
 	}
 
-	if err := c.Run(); err != nil {
+	err := c.Start()
+	if err == nil {
+		var children func() []int
+		if tree {
+			children = ownChildren
+		}
+		fwd.started(c.Process.Pid, children)
+		err = c.Wait()
+		if tree {
+			waitDescendants(debug)
+		}
+		fwd.stopped()
+	}
+	if err != nil {
 		var ee *exec.ExitError
 		if errors.As(err, &ee) {
 			if ws, ok := ee.Sys().(syscall.WaitStatus); ok {
@@ -400,11 +492,6 @@ func runCmd(ctx context.Context, bin string, args []string, debug bool) int {
 	return 0
 }
 
-func hasBinary(name string) bool {
-	_, err := exec.LookPath(name)
-	return err == nil
-}
-
 // logDir returns the directory for ccdpin log files.
 func logDir() (string, error) {
 	// Use XDG state dir if available, otherwise fall back to cache
@@ -463,6 +550,7 @@ func recoverPanic() {
 
 		// Also write to stderr
 		fmt.Fprintf(os.Stderr, "ccdpin: %s\n", msg)
+		releaseSlices()
 		os.Exit(2)
 	}
 }
@@ -484,6 +572,7 @@ func logInfo(format string, args ...any) {
 func fatal(err error) {
 	logError(err)
 	fmt.Fprintln(os.Stderr, "ccdpin:", err)
+	releaseSlices()
 	os.Exit(2)
 }
 
@@ -506,15 +595,6 @@ func debugf(debug bool, format string, args ...any) {
 	fmt.Fprintf(os.Stderr, "ccdpin: %s\n", msg)
 }
 
-type pinState struct {
-	Version             int               `json:"version"`
-	Instances           map[string]uint64 `json:"instances"`
-	OriginalAllowedCPUs map[string]string `json:"original_allowed_cpus"`
-	OSCPUs              string            `json:"os_cpus"`
-	Slices              []string          `json:"slices"`
-	UpdatedAt           time.Time         `json:"updated_at"`
-}
-
 type slicePinManager struct {
 	sys    systemdctl.Systemctl
 	osCPUs string
@@ -524,9 +604,7 @@ type slicePinManager struct {
 	pid     int
 	startTS uint64
 
-	stateDir  string
-	statePath string
-	lockPath  string
+	store pinstate.Store
 }
 
 func newSlicePinManager(sys systemdctl.Systemctl, slices []string, osCPUs string, debug bool) (*slicePinManager, error) {
@@ -536,153 +614,44 @@ func newSlicePinManager(sys systemdctl.Systemctl, slices []string, osCPUs string
 	if len(slices) == 0 {
 		return nil, fmt.Errorf("no slices configured")
 	}
-	stateDir, err := defaultStateDir()
+	store, err := pinstate.Default()
 	if err != nil {
 		return nil, err
 	}
-	if err := os.MkdirAll(stateDir, 0o755); err != nil {
-		return nil, err
-	}
+	store.Logf = warnf
 
 	pid := os.Getpid()
-	startTS, _ := procStartTime(pid)
+	startTS, _ := procscan.StartTime(pid)
 	return &slicePinManager{
-		sys:       sys,
-		osCPUs:    osCPUs,
-		slices:    append([]string{}, slices...),
-		debug:     debug,
-		pid:       pid,
-		startTS:   startTS,
-		stateDir:  stateDir,
-		statePath: filepath.Join(stateDir, "state.json"),
-		lockPath:  filepath.Join(stateDir, "lock"),
+		sys:     sys,
+		osCPUs:  osCPUs,
+		slices:  append([]string{}, slices...),
+		debug:   debug,
+		pid:     pid,
+		startTS: startTS,
+		store:   store,
 	}, nil
 }
 
-func defaultStateDir() (string, error) {
-	base := os.Getenv("XDG_STATE_HOME")
-	if base == "" {
-		home, err := os.UserHomeDir()
-		if err != nil {
-			return "", err
-		}
-		base = filepath.Join(home, ".local", "state")
+// held reports whether other live ccdpin instances already pin the slices.
+func (m *slicePinManager) held() bool {
+	st, err := m.store.Read()
+	if err != nil {
+		return false
 	}
-	return filepath.Join(base, "ccdpin"), nil
+	st.PruneDead()
+	return st.Pinned()
 }
 
 func (m *slicePinManager) AcquireAndPin(ctx context.Context) (func(), error) {
-	unlock, st, err := m.lockAndLoad()
-	if err != nil {
+	pin := func(st *pinstate.State) error { return m.pinSlicesLocked(ctx, st) }
+	if err := m.store.Acquire(m.pid, m.startTS, pin, m.restoreSlice); err != nil {
 		return nil, err
 	}
-
-	changed := false
-	defer func() {
-		if !changed {
-			unlock()
-		}
-	}()
-
-	st = pruneDeadInstances(st)
-	if st.Instances == nil {
-		st.Instances = map[string]uint64{}
-	}
-	instKey := strconv.Itoa(m.pid)
-	st.Instances[instKey] = m.startTS
-
-	if len(st.Instances) == 1 {
-		if err := m.pinSlicesLocked(ctx, &st); err != nil {
-			delete(st.Instances, instKey)
-			_ = m.saveLocked(st)
-			unlock()
-			return nil, err
-		}
-	}
-
-	st.UpdatedAt = time.Now()
-	if err := m.saveLocked(st); err != nil {
-		unlock()
-		return nil, err
-	}
-	unlock()
-	changed = true
-
 	return func() { m.releaseAndRestore(context.Background()) }, nil
 }
 
-func (m *slicePinManager) lockAndLoad() (func(), pinState, error) {
-	f, err := os.OpenFile(m.lockPath, os.O_CREATE|os.O_RDWR, 0o644)
-	if err != nil {
-		return nil, pinState{}, err
-	}
-	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
-		_ = f.Close()
-		return nil, pinState{}, err
-	}
-	unlock := func() {
-		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
-		_ = f.Close()
-	}
-
-	data, err := os.ReadFile(m.statePath)
-	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return unlock, pinState{Version: 1}, nil
-		}
-		unlock()
-		return nil, pinState{}, err
-	}
-	var st pinState
-	if err := json.Unmarshal(data, &st); err != nil {
-		unlock()
-		return nil, pinState{}, err
-	}
-	if st.Version == 0 {
-		st.Version = 1
-	}
-	return unlock, st, nil
-}
-
-func (m *slicePinManager) saveLocked(st pinState) error {
-	if st.Version == 0 {
-		st.Version = 1
-	}
-	data, err := json.MarshalIndent(st, "", "  ")
-	if err != nil {
-		return err
-	}
-	tmp := m.statePath + ".tmp"
-	if err := os.WriteFile(tmp, data, 0o644); err != nil {
-		return err
-	}
-	return os.Rename(tmp, m.statePath)
-}
-
-func pruneDeadInstances(st pinState) pinState {
-	if len(st.Instances) == 0 {
-		return st
-	}
-	out := map[string]uint64{}
-	for k, startTS := range st.Instances {
-		pid, err := strconv.Atoi(k)
-		if err != nil || pid <= 0 {
-			continue
-		}
-		liveStart, err := procStartTime(pid)
-		if err != nil {
-			continue
-		}
-		if startTS != 0 && liveStart != 0 && liveStart != startTS {
-			continue
-		}
-		out[k] = startTS
-	}
-	st.Instances = out
-	return st
-}
-
-func (m *slicePinManager) pinSlicesLocked(_ context.Context, st *pinState) error {
+func (m *slicePinManager) pinSlicesLocked(_ context.Context, st *pinstate.State) error {
 	// Mimic script behavior: skip slices that don't exist.
 	pinned := make([]string, 0, len(m.slices))
 	current := map[string]string{}
@@ -723,6 +692,7 @@ func (m *slicePinManager) pinSlicesLocked(_ context.Context, st *pinState) error
 				_ = m.sys.SetAllowedCPUs(ctx3, u2, orig)
 				cancel3()
 			}
+			st.OriginalAllowedCPUs, st.OSCPUs, st.Slices = nil, "", nil
 			return err
 		}
 	}
@@ -730,52 +700,13 @@ func (m *slicePinManager) pinSlicesLocked(_ context.Context, st *pinState) error
 }
 
 func (m *slicePinManager) releaseAndRestore(_ context.Context) {
-	unlock, st, err := m.lockAndLoad()
-	if err != nil {
-		warnf("release lock: %v", err)
-		return
+	if err := m.store.Release(m.pid, m.startTS, m.restoreSlice); err != nil {
+		warnf("release os slice pin: %v", err)
 	}
-	defer unlock()
-
-	st = pruneDeadInstances(st)
-	if st.Instances != nil {
-		key := strconv.Itoa(m.pid)
-		if startTS, ok := st.Instances[key]; ok {
-			if startTS == 0 || m.startTS == 0 || startTS == m.startTS {
-				delete(st.Instances, key)
-			}
-		}
-	}
-
-	if len(st.Instances) == 0 && len(st.OriginalAllowedCPUs) > 0 {
-		for _, unit := range st.Slices {
-			orig := st.OriginalAllowedCPUs[unit]
-			ctx2, cancel := systemdctl.DefaultContext()
-			_ = m.sys.SetAllowedCPUs(ctx2, unit, orig)
-			cancel()
-		}
-		st.OriginalAllowedCPUs = nil
-		st.OSCPUs = ""
-		st.Slices = nil
-	}
-
-	st.UpdatedAt = time.Now()
-	_ = m.saveLocked(st)
 }
 
-func procStartTime(pid int) (uint64, error) {
-	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
-	if err != nil {
-		return 0, err
-	}
-	line := strings.TrimSpace(string(data))
-	idx := strings.LastIndexByte(line, ')')
-	if idx == -1 || idx+2 >= len(line) {
-		return 0, fmt.Errorf("invalid stat")
-	}
-	fields := strings.Fields(line[idx+2:])
-	if len(fields) <= 19 {
-		return 0, fmt.Errorf("stat too short")
-	}
-	return strconv.ParseUint(fields[19], 10, 64)
+func (m *slicePinManager) restoreSlice(unit, cpus string) error {
+	ctx, cancel := systemdctl.DefaultContext()
+	defer cancel()
+	return m.sys.SetAllowedCPUs(ctx, unit, cpus)
 }
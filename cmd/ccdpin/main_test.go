@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseArgs(t *testing.T) {
+	cases := []struct {
+		name    string
+		args    []string
+		check   func(options) bool
+		cmd     []string
+		wantErr bool
+	}{
+		{name: "no args"},
+		{name: "command only", args: []string{"/games/run.sh", "-foo"}, cmd: []string{"/games/run.sh", "-foo"}},
+		{name: "flags then command", args: []string{"--swap", "--game-cpus", "8-15", "/games/run.sh", "--swap"},
+			check: func(o options) bool { return o.swap && o.gameCPUs == "8-15" },
+			cmd:   []string{"/games/run.sh", "--swap"}},
+		{name: "flag with equals", args: []string{"--os-cpus=0-7", "game"},
+			check: func(o options) bool { return o.osCPUs == "0-7" },
+			cmd:   []string{"game"}},
+		{name: "single dash flag", args: []string{"-no-os-pin", "game"},
+			check: func(o options) bool { return o.noOSPin },
+			cmd:   []string{"game"}},
+		{name: "bool flag with value", args: []string{"--no-scope=false", "game"},
+			check: func(o options) bool { return !o.noScope },
+			cmd:   []string{"game"}},
+		{name: "double dash separator", args: []string{"--swap", "--", "--print", "x"},
+			check: func(o options) bool { return o.swap && !o.print },
+			cmd:   []string{"--print", "x"}},
+		{name: "command beginning with dash", args: []string{"--swap", "-game", "--opt"},
+			check: func(o options) bool { return o.swap },
+			cmd:   []string{"-game", "--opt"}},
+		{name: "command beginning with double dash", args: []string{"--launch", "x"}, cmd: []string{"--launch", "x"}},
+		{name: "lone dash is a command", args: []string{"-"}, cmd: []string{"-"}},
+		{name: "triple dash is a command", args: []string{"---x"}, cmd: []string{"---x"}},
+		{name: "grace duration", args: []string{"--grace", "3s", "game"},
+			check: func(o options) bool { return o.grace == 3*time.Second },
+			cmd:   []string{"game"}},
+		{name: "grace unset", args: []string{"game"},
+			check: func(o options) bool { return o.grace < 0 },
+			cmd:   []string{"game"}},
+		{name: "print", args: []string{"--print"}, check: func(o options) bool { return o.print }},
+		{name: "missing flag value", args: []string{"--game-cpus"}, wantErr: true},
+		{name: "invalid duration", args: []string{"--grace", "soon", "game"}, wantErr: true},
+		{name: "exclusive actions", args: []string{"--print", "--state"}, wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts, cmd, err := parseArgs(tc.args, io.Discard, io.Discard)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got opts=%+v cmd=%q", opts, cmd)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseArgs: %v", err)
+			}
+			if !reflect.DeepEqual(cmd, tc.cmd) && (len(cmd) != 0 || len(tc.cmd) != 0) {
+				t.Fatalf("cmd = %q, want %q", cmd, tc.cmd)
+			}
+			if tc.check != nil && !tc.check(opts) {
+				t.Fatalf("unexpected options: %+v", opts)
+			}
+		})
+	}
+}
+
+func TestParseArgsHelp(t *testing.T) {
+	for _, arg := range []string{"-h", "--help"} {
+		if _, _, err := parseArgs([]string{arg}, io.Discard, io.Discard); !errors.Is(err, flag.ErrHelp) {
+			t.Fatalf("%s: expected flag.ErrHelp, got %v", arg, err)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/pinstate"
+	"github.com/Reidond/ccdbind/internal/systemdctl"
+)
+
+// runMaintenance handles --state, --prune and --restore, which work on the
+// shared pin state without launching a game. They are mainly for recovering
+// after a ccdpin was SIGKILLed while holding the slices.
+func runMaintenance(opts options, out io.Writer) error {
+	store, err := pinstate.Default()
+	if err != nil {
+		return err
+	}
+	store.Logf = warnf
+	if opts.showState {
+		return printPinState(store, out)
+	}
+
+	// Recover loads the backup of a corrupt file, so report what it saw
+	// rather than a Read from before.
+	sys := systemdctl.Systemctl{}
+	rec, err := store.Recover(opts.restore, func(unit, cpus string) error {
+		ctx, cancel := systemdctl.DefaultContext()
+		defer cancel()
+		return sys.SetAllowedCPUs(ctx, unit, cpus)
+	})
+	if opts.restore && len(rec.Live) > 0 {
+		warnf("forced restore while ccdpin was running (pids %s); their games lost the OS-slice pin", strings.Join(sortedPIDs(rec.Live), ", "))
+	}
+	if rec.Pruned > 0 {
+		fmt.Fprintf(out, "pruned %d dead instance(s)\n", rec.Pruned)
+	}
+	for _, unit := range rec.Restored {
+		fmt.Fprintf(out, "restored %s AllowedCPUs=%s\n", unit, orAll(rec.Originals[unit]))
+	}
+	if err != nil {
+		return err
+	}
+	if rec.Pinned {
+		fmt.Fprintf(out, "slices still held by running ccdpin (pids %s)\n", strings.Join(sortedPIDs(rec.Live), ", "))
+	} else if len(rec.Restored) == 0 && rec.Pruned == 0 {
+		fmt.Fprintln(out, "nothing to do")
+	}
+	return nil
+}
+
+func printPinState(store pinstate.Store, out io.Writer) error {
+	st, err := store.Read()
+	if errors.Is(err, pinstate.ErrCorrupt) {
+		return fmt.Errorf("%w\nrun `ccdpin --prune` to move it aside and load the backup", err)
+	}
+	if err != nil {
+		return err
+	}
+	live := st
+	live.PruneDead()
+
+	fmt.Fprintf(out, "state: %s\n", store.Path)
+	if !st.UpdatedAt.IsZero() {
+		fmt.Fprintf(out, "updated: %s\n", st.UpdatedAt.Format(time.RFC3339))
+	}
+	switch {
+	case live.Pinned():
+		fmt.Fprintf(out, "pin: held (os_cpus=%s)\n", st.OSCPUs)
+	case len(st.OriginalAllowedCPUs) > 0:
+		fmt.Fprintf(out, "pin: stale (os_cpus=%s); run `ccdpin --prune` to restore\n", st.OSCPUs)
+	default:
+		fmt.Fprintln(out, "pin: none")
+	}
+	for _, unit := range st.Slices {
+		fmt.Fprintf(out, "  %s original AllowedCPUs=%s\n", unit, orAll(st.OriginalAllowedCPUs[unit]))
+	}
+	if len(st.Instances) == 0 {
+		fmt.Fprintln(out, "instances: none")
+		return nil
+	}
+	fmt.Fprintln(out, "instances:")
+	for _, pid := range sortedPIDs(st.Instances) {
+		status := "dead"
+		if _, ok := live.Instances[pid]; ok {
+			status = "alive"
+		}
+		fmt.Fprintf(out, "  pid %s (%s)\n", pid, status)
+	}
+	return nil
+}
+
+func sortedPIDs(instances map[string]uint64) []string {
+	out := make([]string, 0, len(instances))
+	for pid := range instances {
+		out = append(out, pid)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if len(out[i]) != len(out[j]) {
+			return len(out[i]) < len(out[j])
+		}
+		return out[i] < out[j]
+	})
+	return out
+}
+
+// orAll shows an empty AllowedCPUs, i.e. no restriction, as "all".
+func orAll(cpus string) string {
+	if cpus == "" {
+		return "all"
+	}
+	return cpus
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Reidond/ccdbind/internal/control"
+	"github.com/Reidond/ccdbind/internal/pinstate"
+	"github.com/Reidond/ccdbind/internal/systemdctl"
+)
+
+// explainOrder is the order --explain lists the settings in.
+var explainOrder = []struct {
+	key   string
+	value func(resolved) string
+}{
+	{"game_id", func(r resolved) string { return orNone(r.gameID) }},
+	{"os_cpus", func(r resolved) string { return orNone(r.osCPUs) }},
+	{"game_cpus", func(r resolved) string { return r.gameCPUs }},
+	{"os_slices", func(r resolved) string { return strings.Join(r.osSlices, " ") }},
+	{"swap", func(r resolved) string { return fmt.Sprint(r.swap) }},
+	{"no_os_pin", func(r resolved) string { return fmt.Sprint(r.noOSPin) }},
+	{"no_scope", func(r resolved) string { return fmt.Sprint(r.noScope) }},
+	{"no_subreaper", func(r resolved) string { return fmt.Sprint(r.noSubreaper) }},
+	{"grace", func(r resolved) string { return r.grace.String() }},
+	{"debug", func(r resolved) string { return fmt.Sprint(r.debug) }},
+}
+
+// printExplain lists each resolved setting with the source it came from.
+func printExplain(w io.Writer, r resolved) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, e := range explainOrder {
+		fmt.Fprintf(tw, "%s\t= %s\t%s\n", e.key, e.value(r), r.from[e.key])
+	}
+	tw.Flush()
+}
+
+// printPlan describes what running cmd would do without changing anything:
+// who pins the OS slices and how their AllowedCPUs would change, the scope
+// the game would run in, and the command line. sys only reads.
+func printPlan(w io.Writer, r resolved, cmd []string, sys systemdctl.Systemctl) {
+	if r.gameID != "" {
+		fmt.Fprintf(w, "game: %s\n", r.gameID)
+	}
+
+	if r.noOSPin {
+		fmt.Fprintf(w, "os slices: not pinned (%s)\n", r.from["no_os_pin"])
+	} else {
+		fmt.Fprintf(w, "os slices: %s\n", slicePinHolder())
+		for _, unit := range r.osSlices {
+			ctx, cancel := systemdctl.DefaultContext()
+			cur, err := sys.GetAllowedCPUs(ctx, unit)
+			cancel()
+			if err != nil {
+				fmt.Fprintf(w, "  %-18s skipped (%v)\n", unit, err)
+				continue
+			}
+			fmt.Fprintf(w, "  %-18s AllowedCPUs %s -> %s\n", unit, orAll(cur), r.osCPUs)
+		}
+	}
+
+	if r.noScope {
+		fmt.Fprintf(w, "game scope: none (%s)\n", r.from["no_scope"])
+		fmt.Fprintf(w, "  ccdpin sets its own affinity to %s (sched_setaffinity on all threads); the game inherits it\n", r.gameCPUs)
+		fmt.Fprintf(w, "exec: %s\n", shellJoin(cmd))
+	} else {
+		fmt.Fprintf(w, "game scope: %s in game.slice, AllowedCPUs=%s\n", gameScopeName(r.gameID, os.Getpid()), r.gameCPUs)
+		fmt.Fprintln(w, "  ccdpin moves itself in over D-Bus (StartTransientUnit, or AttachProcessesToUnit if it exists)")
+		fmt.Fprintf(w, "exec: %s\n", shellJoin(cmd))
+	}
+	if r.grace > 0 {
+		fmt.Fprintf(w, "signals: forwarded to the game, SIGKILL %v after a terminating one\n", r.grace)
+	} else {
+		fmt.Fprintln(w, "signals: forwarded to the game, no SIGKILL (grace 0)")
+	}
+	if r.noSubreaper {
+		fmt.Fprintln(w, "wait: direct child only")
+	} else {
+		fmt.Fprintln(w, "wait: all descendants; terminating signals also reach reparented ones")
+	}
+}
+
+// slicePinHolder says who would own the OS-slice pin, following the order
+// holdOSSlices tries.
+func slicePinHolder() string {
+	if store, err := pinstate.Default(); err == nil {
+		if st, err := store.Read(); err == nil {
+			st.PruneDead()
+			if st.Pinned() {
+				return fmt.Sprintf("already pinned by ccdpin (pids %s); would join", strings.Join(sortedPIDs(st.Instances), ", "))
+			}
+		}
+	}
+	if c, err := control.Dial(); err == nil {
+		c.Close()
+		return "pinned by the ccdbind daemon (RegisterLauncher)"
+	}
+	return "pinned by ccdpin"
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "(none)"
+	}
+	return s
+}
+
+// shellJoin quotes args so the line can be pasted into a shell.
+func shellJoin(args []string) string {
+	out := make([]string, len(args))
+	for i, a := range args {
+		if a != "" && strings.IndexFunc(a, func(r rune) bool {
+			return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./=:,+%@", r))
+		}) < 0 {
+			out[i] = a
+			continue
+		}
+		out[i] = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+	}
+	return strings.Join(out, " ")
+}
@@ -0,0 +1,11 @@
+package main
+
+import "testing"
+
+func TestShellJoin(t *testing.T) {
+	got := shellJoin([]string{"/games/run.sh", "-w", "My Game", "it's", "", "%command%"})
+	want := `/games/run.sh -w 'My Game' 'it'\''s' '' %command%`
+	if got != want {
+		t.Fatalf("shellJoin = %s, want %s", got, want)
+	}
+}
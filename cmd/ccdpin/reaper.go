@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// prSetChildSubreaper is PR_SET_CHILD_SUBREAPER from <linux/prctl.h>.
+const prSetChildSubreaper = 36
+
+// setChildSubreaper makes ccdpin the parent of any descendant orphaned by
+// its own parent, instead of init or the session's subreaper.
+func setChildSubreaper() error {
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetChildSubreaper, 1, 0); errno != 0 {
+		return fmt.Errorf("prctl(PR_SET_CHILD_SUBREAPER): %w", errno)
+	}
+	return nil
+}
+
+// waitDescendants reaps children until none are left. Once the direct child
+// is gone these are the descendants that were reparented to ccdpin.
+func waitDescendants(debug bool) {
+	for {
+		var ws syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &ws, 0, nil)
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		}
+		if err != nil {
+			if !errors.Is(err, syscall.ECHILD) {
+				logInfo("wait for descendants: %v", err)
+			}
+			return
+		}
+		if ws.Signaled() {
+			debugf(debug, "descendant %d killed by %v", pid, ws.Signal())
+		} else {
+			debugf(debug, "descendant %d exited with status %d", pid, ws.ExitStatus())
+		}
+	}
+}
+
+// ownChildren lists ccdpin's children from /proc/self/task/*/children. As
+// subreaper these include orphaned descendants that left the game's
+// process group with setsid or setpgid.
+func ownChildren() []int {
+	tasks, _ := filepath.Glob("/proc/self/task/*/children")
+	var out []int
+	for _, path := range tasks {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		for _, f := range strings.Fields(string(data)) {
+			if pid, err := strconv.Atoi(f); err == nil {
+				out = append(out, pid)
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/config"
+	"github.com/Reidond/ccdbind/internal/topology"
+)
+
+// sources are the inputs resolve reads besides flags and the config, so
+// tests can supply a fixed environment and topology.
+type sources struct {
+	getenv func(string) string
+	detect func() (topology.Result, error)
+}
+
+func systemSources() sources {
+	return sources{getenv: os.Getenv, detect: topology.Detect}
+}
+
+// loadConfig loads the ccdbind config so ccdpin shares its CPU overrides,
+// slice list and per-game profiles. On error the defaults are returned.
+func loadConfig(path string) (config.Config, error) {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		p, err := config.DefaultConfigPath()
+		if err != nil {
+			return config.Default(), err
+		}
+		path = p
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		return config.Default(), err
+	}
+	return cfg, nil
+}
+
+// choice is a candidate setting and where it comes from, for --explain.
+type choice struct {
+	value string
+	from  string
+}
+
+// pick returns the first candidate with a non-empty value.
+func pick(cands ...choice) choice {
+	for _, c := range cands {
+		if v := strings.TrimSpace(c.value); v != "" {
+			return choice{value: v, from: c.from}
+		}
+	}
+	return choice{}
+}
+
+// resolve merges the settings; each value comes from the first source that
+// sets it: flags, STEAM_CCD_* environment, the game's profile, the global
+// config, then topology detection. r.from records the source of each
+// setting for --explain.
+func resolve(opts options, cfg config.Config, src sources) (resolved, error) {
+	from := map[string]string{}
+
+	// envBool reads a STEAM_CCD_* switch, keeping the first invalid value;
+	// a set flag wins over it.
+	var err error
+	envBool := func(key, k string, flagSet bool, flagName string) bool {
+		v, perr := parseBoolEnv(k, src.getenv(k))
+		if perr != nil && err == nil {
+			err = perr
+		}
+		switch {
+		case flagSet:
+			from[key] = "flag --" + flagName
+		case strings.TrimSpace(src.getenv(k)) != "":
+			from[key] = "env " + k
+		default:
+			from[key] = "default"
+		}
+		return flagSet || v
+	}
+	debug := envBool("debug", envDebug, false, "")
+	noOSPin := envBool("no_os_pin", envNoOSPin, opts.noOSPin, "no-os-pin")
+	swap := envBool("swap", envSwap, opts.swap, "swap")
+	noSubreaper := envBool("no_subreaper", envNoSubreaper, opts.noSubreaper, "no-subreaper")
+	noScope := envBool("no_scope", envNoScope, opts.noScope, "no-scope")
+	if err != nil {
+		return resolved{}, err
+	}
+
+	gameID := ""
+	from["game_id"] = "none of env_keys set"
+	for _, k := range cfg.EnvKeys {
+		if v := strings.TrimSpace(src.getenv(k)); v != "" && v != "0" {
+			gameID = v
+			from["game_id"] = "env " + k
+			break
+		}
+	}
+	var profile *config.GameProfile
+	profileFrom := ""
+	if p, ok := cfg.Games[gameID]; ok && gameID != "" {
+		profile = &p
+		profileFrom = fmt.Sprintf("profile [games.%q]", gameID)
+	}
+	// Affinity mode is for games that must not be moved between cgroups,
+	// which for ccdpin means no scope.
+	if !noScope && cfg.ModeFor(gameID) == config.ModeAffinity {
+		noScope = true
+		from["no_scope"] = "config mode = \"affinity\""
+		if profile != nil && profile.Mode != "" {
+			from["no_scope"] = profileFrom + " mode = \"affinity\""
+		}
+	}
+
+	osSlices := parseSlicesEnv(src.getenv(envOSSlices))
+	from["os_slices"] = "env " + envOSSlices
+	if len(osSlices) == 0 && profile != nil && len(profile.PinSlices) > 0 {
+		osSlices = profile.PinSlices
+		from["os_slices"] = profileFrom + " pin_slices"
+	}
+	if len(osSlices) == 0 {
+		osSlices = cfg.OSSlices()
+		from["os_slices"] = "config pin_slices/pin_session_slice"
+	}
+
+	var profileOS, profileGame string
+	if profile != nil {
+		profileOS, profileGame = profile.OSCPUs, profile.GameCPUs
+	}
+	osPick := pick(
+		choice{opts.osCPUs, "flag --os-cpus"},
+		choice{src.getenv(envOSCPUs), "env " + envOSCPUs},
+		choice{profileOS, profileFrom + " os_cpus"},
+		choice{cfg.OSCPUsOverride, "config os_cpus"},
+	)
+	gamePick := pick(
+		choice{opts.gameCPUs, "flag --game-cpus"},
+		choice{src.getenv(envGameCPUs), "env " + envGameCPUs},
+		choice{profileGame, profileFrom + " game_cpus"},
+		choice{cfg.GameCPUsOverride, "config game_cpus"},
+	)
+	osCPUs, gameCPUs := osPick.value, gamePick.value
+	from["os_cpus"], from["game_cpus"] = osPick.from, gamePick.from
+
+	// Match the script behavior:
+	// - If both OS+GAME are provided explicitly, use them.
+	// - Otherwise auto-detect and fill missing.
+	var det topology.Result
+	needDetect := opts.print || osCPUs == "" || gameCPUs == "" || swap
+	if needDetect {
+		res, err := src.detect()
+		if err != nil {
+			return resolved{}, err
+		}
+		det = res
+	}
+	if osCPUs == "" {
+		osCPUs = det.OSCPUs
+		from["os_cpus"] = "detected (L3 group with CPU 0)"
+	}
+	if gameCPUs == "" {
+		gameCPUs = det.GameCPUs
+		from["game_cpus"] = "detected (other L3 groups)"
+	}
+	if strings.TrimSpace(gameCPUs) == "" {
+		return resolved{}, fmt.Errorf("could not resolve GAME_CPUS")
+	}
+
+	grace := opts.grace
+	from["grace"] = "flag --grace"
+	if grace < 0 {
+		grace = defaultGrace
+		from["grace"] = "default"
+		if v := strings.TrimSpace(src.getenv(envGrace)); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				return resolved{}, fmt.Errorf("invalid %s %q", envGrace, v)
+			}
+			grace = d
+			from["grace"] = "env " + envGrace
+		}
+	}
+
+	if strings.TrimSpace(osCPUs) != "" {
+		osCPUs, _, err = topology.CanonicalizeCPUList(osCPUs)
+		if err != nil {
+			return resolved{}, fmt.Errorf("invalid OS CPU list %q: %w", osCPUs, err)
+		}
+	}
+	gameCPUs, _, err = topology.CanonicalizeCPUList(gameCPUs)
+	if err != nil {
+		return resolved{}, fmt.Errorf("invalid GAME CPU list %q: %w", gameCPUs, err)
+	}
+
+	if swap {
+		if strings.TrimSpace(osCPUs) == "" {
+			return resolved{}, fmt.Errorf("cannot swap without OS_CPUS")
+		}
+		osCPUs, gameCPUs = gameCPUs, osCPUs
+		from["os_cpus"], from["game_cpus"] = from["game_cpus"]+", swapped", from["os_cpus"]+", swapped"
+	}
+
+	return resolved{osCPUs: osCPUs, gameCPUs: gameCPUs, ccds: det.Lists, swap: swap, noOSPin: noOSPin, noScope: noScope, noSubreaper: noSubreaper, osSlices: osSlices, debug: debug, grace: grace, gameID: gameID, profile: profile, from: from}, nil
+}
+
+func printTopology(w io.Writer, r resolved) {
+	if len(r.ccds) > 0 {
+		fmt.Fprintln(w, "Detected CCD CPU groups:")
+		for i, s := range r.ccds {
+			fmt.Fprintf(w, "  CCD[%d] = %s\n", i, strings.TrimSpace(s))
+		}
+		fmt.Fprintln(w, "")
+	}
+	if r.gameID != "" {
+		name := ""
+		if r.profile != nil {
+			name = " (profile"
+			if r.profile.Name != "" {
+				name += " " + r.profile.Name
+			}
+			name += ")"
+		}
+		fmt.Fprintf(w, "Game: %s%s\n\n", r.gameID, name)
+	}
+	fmt.Fprintln(w, "Selected:")
+	if r.osCPUs != "" {
+		fmt.Fprintf(w, "  OS_CPUS   = %s\n", r.osCPUs)
+	}
+	fmt.Fprintf(w, "  GAME_CPUS = %s\n", r.gameCPUs)
+	if len(r.osSlices) > 0 {
+		fmt.Fprintf(w, "  OS_SLICES = %s\n", strings.Join(r.osSlices, " "))
+	}
+}
+
+func parseSlicesEnv(v string) []string {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return nil
+	}
+	fields := strings.Fields(v)
+	out := make([]string, 0, len(fields))
+	seen := map[string]struct{}{}
+	for _, f := range fields {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if !strings.HasSuffix(f, ".slice") {
+			continue
+		}
+		if _, ok := seen[f]; ok {
+			continue
+		}
+		seen[f] = struct{}{}
+		out = append(out, f)
+	}
+	return out
+}
+
+// parseBoolEnv parses the value v of the environment variable k. Unset is
+// false; a value that is neither true nor false is an error rather than a
+// guess.
+func parseBoolEnv(k, v string) (bool, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return false, nil
+	}
+	switch strings.ToLower(v) {
+	case "1", "true", "yes", "y", "on", "enable", "enabled":
+		return true, nil
+	case "0", "false", "no", "n", "off", "disable", "disabled":
+		return false, nil
+	default:
+		return false, fmt.Errorf("invalid %s %q (expected 1/0, true/false, yes/no or on/off)", k, v)
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/config"
+	"github.com/Reidond/ccdbind/internal/topology"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files")
+
+// twoCCDs is a 16-core, two-CCD part with SMT.
+var twoCCDs = topology.Result{
+	OSCPUs:   "0-7,16-23",
+	GameCPUs: "8-15,24-31",
+	Lists:    []string{"0-7,16-23", "8-15,24-31"},
+}
+
+func testSources(env map[string]string, det topology.Result, detErr error) sources {
+	return sources{
+		getenv: func(k string) string { return env[k] },
+		detect: func() (topology.Result, error) { return det, detErr },
+	}
+}
+
+func noOpts() options {
+	return options{grace: -1}
+}
+
+func withProfile(p config.GameProfile) config.Config {
+	cfg := config.Default()
+	cfg.Games = map[string]config.GameProfile{"570": p}
+	return cfg
+}
+
+func TestResolve(t *testing.T) {
+	errNoSysfs := errors.New("no sysfs")
+	cases := []struct {
+		name    string
+		opts    func(*options)
+		env     map[string]string
+		cfg     config.Config
+		det     topology.Result
+		detErr  error
+		want    resolved
+		wantErr string
+	}{
+		{
+			name: "detection",
+			want: resolved{osCPUs: "0-7,16-23", gameCPUs: "8-15,24-31"},
+		},
+		{
+			name: "flag beats env",
+			opts: func(o *options) { o.gameCPUs = "8-11" },
+			env:  map[string]string{envGameCPUs: "12-15"},
+			want: resolved{osCPUs: "0-7,16-23", gameCPUs: "8-11"},
+		},
+		{
+			name: "env beats profile",
+			env:  map[string]string{"SteamAppId": "570", envGameCPUs: "12-15"},
+			cfg:  withProfile(config.GameProfile{GameCPUs: "8-9"}),
+			want: resolved{osCPUs: "0-7,16-23", gameCPUs: "12-15", gameID: "570"},
+		},
+		{
+			name: "profile beats global config",
+			env:  map[string]string{"SteamAppId": "570"},
+			cfg: func() config.Config {
+				cfg := withProfile(config.GameProfile{OSCPUs: "0-1"})
+				cfg.OSCPUsOverride = "0-3"
+				cfg.GameCPUsOverride = "4-7"
+				return cfg
+			}(),
+			want: resolved{osCPUs: "0-1", gameCPUs: "4-7", gameID: "570"},
+		},
+		{
+			name:   "explicit lists skip detection",
+			opts:   func(o *options) { o.osCPUs, o.gameCPUs = "0-3", "4-7" },
+			detErr: errNoSysfs,
+			want:   resolved{osCPUs: "0-3", gameCPUs: "4-7"},
+		},
+		{
+			name:    "detection error",
+			detErr:  errNoSysfs,
+			wantErr: "no sysfs",
+		},
+		{
+			name: "swap",
+			opts: func(o *options) { o.swap = true },
+			want: resolved{osCPUs: "8-15,24-31", gameCPUs: "0-7,16-23"},
+		},
+		{
+			name: "swap from env",
+			env:  map[string]string{envSwap: "yes"},
+			want: resolved{osCPUs: "8-15,24-31", gameCPUs: "0-7,16-23"},
+		},
+		{
+			name:    "swap without OS CPUs",
+			opts:    func(o *options) { o.swap = true },
+			det:     topology.Result{GameCPUs: "0-15"},
+			wantErr: "cannot swap without OS_CPUS",
+		},
+		{
+			name:    "invalid GAME list",
+			opts:    func(o *options) { o.gameCPUs = "8-x" },
+			wantErr: "invalid GAME CPU list",
+		},
+		{
+			name:    "invalid OS list from env",
+			env:     map[string]string{envOSCPUs: "7-0"},
+			wantErr: "invalid OS CPU list",
+		},
+		{
+			name:    "unknown bool value",
+			env:     map[string]string{envNoOSPin: "maybe"},
+			wantErr: "invalid " + envNoOSPin,
+		},
+		{
+			name: "false bool value",
+			env:  map[string]string{envSwap: "0", envNoOSPin: "off"},
+			want: resolved{osCPUs: "0-7,16-23", gameCPUs: "8-15,24-31"},
+		},
+		{
+			name: "slices from env beat profile",
+			env:  map[string]string{"SteamAppId": "570", envOSSlices: "background.slice bogus background.slice"},
+			cfg:  withProfile(config.GameProfile{PinSlices: []string{"app.slice"}}),
+			want: resolved{osCPUs: "0-7,16-23", gameCPUs: "8-15,24-31", gameID: "570", osSlices: []string{"background.slice"}},
+		},
+		{
+			name: "profile slices",
+			env:  map[string]string{"SteamAppId": "570"},
+			cfg:  withProfile(config.GameProfile{PinSlices: []string{"app.slice"}}),
+			want: resolved{osCPUs: "0-7,16-23", gameCPUs: "8-15,24-31", gameID: "570", osSlices: []string{"app.slice"}},
+		},
+		{
+			name: "affinity profile means no scope",
+			env:  map[string]string{"SteamAppId": "570"},
+			cfg:  withProfile(config.GameProfile{Mode: config.ModeAffinity}),
+			want: resolved{osCPUs: "0-7,16-23", gameCPUs: "8-15,24-31", gameID: "570", noScope: true},
+		},
+		{
+			name: "game id 0 is ignored",
+			env:  map[string]string{"SteamAppId": "0", "SteamGameId": "570"},
+			want: resolved{osCPUs: "0-7,16-23", gameCPUs: "8-15,24-31", gameID: "570"},
+		},
+		{
+			name: "grace from env",
+			env:  map[string]string{envGrace: "30s"},
+			want: resolved{osCPUs: "0-7,16-23", gameCPUs: "8-15,24-31", grace: 30 * time.Second},
+		},
+		{
+			name: "grace flag beats env",
+			opts: func(o *options) { o.grace = 0 },
+			env:  map[string]string{envGrace: "30s"},
+			want: resolved{osCPUs: "0-7,16-23", gameCPUs: "8-15,24-31", grace: 0},
+		},
+		{
+			name:    "invalid grace",
+			env:     map[string]string{envGrace: "-1s"},
+			wantErr: "invalid " + envGrace,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := noOpts()
+			if tc.opts != nil {
+				tc.opts(&opts)
+			}
+			cfg := tc.cfg
+			if cfg.EnvKeys == nil {
+				cfg = config.Default()
+			}
+			det := tc.det
+			if det.GameCPUs == "" && det.OSCPUs == "" {
+				det = twoCCDs
+			}
+			got, err := resolve(opts, cfg, testSources(tc.env, det, tc.detErr))
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolve: %v", err)
+			}
+
+			want := tc.want
+			if want.osSlices == nil {
+				want.osSlices = cfg.OSSlices()
+			}
+			if opts.grace < 0 && tc.env[envGrace] == "" {
+				want.grace = defaultGrace
+			}
+			if got.osCPUs != want.osCPUs || got.gameCPUs != want.gameCPUs {
+				t.Fatalf("cpus os=%q game=%q, want os=%q game=%q", got.osCPUs, got.gameCPUs, want.osCPUs, want.gameCPUs)
+			}
+			if got.gameID != want.gameID || got.noScope != want.noScope || got.grace != want.grace {
+				t.Fatalf("game=%q noScope=%v grace=%v, want game=%q noScope=%v grace=%v",
+					got.gameID, got.noScope, got.grace, want.gameID, want.noScope, want.grace)
+			}
+			if !reflect.DeepEqual(got.osSlices, want.osSlices) {
+				t.Fatalf("slices = %q, want %q", got.osSlices, want.osSlices)
+			}
+		})
+	}
+}
+
+func TestParseBoolEnv(t *testing.T) {
+	for _, v := range []string{"1", "true", "YES", " on ", "enabled"} {
+		if got, err := parseBoolEnv("K", v); err != nil || !got {
+			t.Fatalf("%q: got %v, %v", v, got, err)
+		}
+	}
+	for _, v := range []string{"", "0", "False", "no", "off", "disabled"} {
+		if got, err := parseBoolEnv("K", v); err != nil || got {
+			t.Fatalf("%q: got %v, %v", v, got, err)
+		}
+	}
+	if _, err := parseBoolEnv("K", "2"); err == nil {
+		t.Fatalf("expected error for unknown value")
+	}
+}
+
+// TestPrintGolden compares `ccdpin --print` output with testdata/*.golden.
+// Run with -update to rewrite them.
+func TestPrintGolden(t *testing.T) {
+	cases := []struct {
+		name string
+		opts func(*options)
+		env  map[string]string
+		cfg  config.Config
+	}{
+		{name: "print_detected"},
+		{name: "print_swap", opts: func(o *options) { o.swap = true }},
+		{
+			name: "print_profile",
+			env:  map[string]string{"SteamAppId": "570", envOSSlices: "background.slice"},
+			cfg:  withProfile(config.GameProfile{Name: "Dota 2", GameCPUs: "8-15"}),
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := noOpts()
+			opts.print = true
+			if tc.opts != nil {
+				tc.opts(&opts)
+			}
+			cfg := tc.cfg
+			if cfg.EnvKeys == nil {
+				cfg = config.Default()
+			}
+			r, err := resolve(opts, cfg, testSources(tc.env, twoCCDs, nil))
+			if err != nil {
+				t.Fatalf("resolve: %v", err)
+			}
+			var buf bytes.Buffer
+			printTopology(&buf, r)
+			checkGolden(t, tc.name, buf.Bytes())
+		})
+	}
+}
+
+// TestExplainGolden compares `ccdpin --explain` output with testdata.
+func TestExplainGolden(t *testing.T) {
+	opts := noOpts()
+	opts.explain = true
+	opts.osCPUs = "0-3"
+	env := map[string]string{"SteamAppId": "570", envSwap: "1", envGrace: "20s"}
+	cfg := withProfile(config.GameProfile{Mode: config.ModeAffinity, PinSlices: []string{"app.slice"}})
+	r, err := resolve(opts, cfg, testSources(env, twoCCDs, nil))
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	var buf bytes.Buffer
+	printExplain(&buf, r)
+	checkGolden(t, "explain", buf.Bytes())
+}
+
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read golden (run with -update): %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("output mismatch for %s:\n--- got\n%s--- want\n%s", path, got, want)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// defaultGrace is how long the game gets to exit after a terminating signal
+// before its process group is killed.
+const defaultGrace = 10 * time.Second
+
+// forwardedSignals are relayed to the game's process group. SIGCHLD concerns
+// ccdpin's own children, SIGTTIN/SIGTTOU are about ccdpin's own terminal
+// access, and SIGKILL/SIGSTOP cannot be caught.
+var forwardedSignals = []os.Signal{
+	syscall.SIGHUP, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM,
+	syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGALRM, syscall.SIGWINCH,
+	syscall.SIGCONT, syscall.SIGTSTP,
+}
+
+func isTerminating(sig os.Signal) bool {
+	switch sig {
+	case syscall.SIGHUP, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM:
+		return true
+	}
+	return false
+}
+
+// reapKillInterval is how often ccdpin re-sends SIGKILL after the grace
+// period, to catch descendants reparented to it since the last round.
+const reapKillInterval = 250 * time.Millisecond
+
+// forwarder relays ccdpin's signals to the game's process group. A
+// terminating signal starts the grace period; when it runs out, or a second
+// terminating signal arrives, the group is sent SIGKILL. Signals that arrive
+// before the game started cancel the launch instead.
+//
+// As subreaper, ccdpin also waits for descendants that left the game's
+// process group, so terminating signals and SIGKILL go to its other
+// children too, and SIGKILL is repeated until the game is reaped.
+type forwarder struct {
+	grace  time.Duration
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	pgid     int
+	children func() []int
+	stopping os.Signal
+	kill     *time.Timer
+	killed   bool
+	done     chan struct{}
+}
+
+func newForwarder(grace time.Duration, cancel context.CancelFunc) *forwarder {
+	return &forwarder{grace: grace, cancel: cancel}
+}
+
+// listen installs the signal handler. It must be called before anything is
+// pinned so that no signal can end ccdpin without the restore running.
+func (f *forwarder) listen() {
+	sigc := make(chan os.Signal, 8)
+	signal.Notify(sigc, forwardedSignals...)
+	go func() {
+		for sig := range sigc {
+			f.handle(sig)
+		}
+	}()
+}
+
+func (f *forwarder) handle(sig os.Signal) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.pgid == 0 {
+		if isTerminating(sig) && f.stopping == nil {
+			logInfo("received %v before the game started; not launching", sig)
+			f.stopping = sig
+			f.cancel()
+		}
+		return
+	}
+
+	logInfo("forwarding %v to process group %d", sig, f.pgid)
+	if !isTerminating(sig) {
+		_ = syscall.Kill(-f.pgid, sig.(syscall.Signal))
+		return
+	}
+	f.signalLocked(sig.(syscall.Signal))
+	if f.stopping != nil {
+		warnf("received %v again; killing the game", sig)
+		f.killLocked()
+		return
+	}
+	f.stopping = sig
+	f.armLocked()
+}
+
+// armLocked starts the grace period for the current process group. A zero
+// grace waits for the game indefinitely.
+func (f *forwarder) armLocked() {
+	if f.grace <= 0 || f.kill != nil {
+		return
+	}
+	pgid, sig := f.pgid, f.stopping
+	f.kill = time.AfterFunc(f.grace, func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.pgid != pgid {
+			return
+		}
+		warnf("game still running %v after %v; killing it", f.grace, sig)
+		f.killLocked()
+	})
+}
+
+// signalLocked sends sig to the game's process group and to every other
+// child of ccdpin, as a group if the child leads one.
+func (f *forwarder) signalLocked(sig syscall.Signal) {
+	_ = syscall.Kill(-f.pgid, sig)
+	if f.children == nil {
+		return
+	}
+	for _, pid := range f.children() {
+		pgid, err := syscall.Getpgid(pid)
+		switch {
+		case err != nil:
+			continue
+		case pgid == f.pgid:
+		case pgid == pid:
+			_ = syscall.Kill(-pid, sig)
+		default:
+			_ = syscall.Kill(pid, sig)
+		}
+	}
+}
+
+func (f *forwarder) killLocked() {
+	if f.pgid == 0 || f.killed {
+		return
+	}
+	f.killed = true
+	if f.kill != nil {
+		f.kill.Stop()
+	}
+	f.signalLocked(syscall.SIGKILL)
+	if f.children == nil {
+		return
+	}
+	done := make(chan struct{})
+	f.done = done
+	go func() {
+		t := time.NewTicker(reapKillInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				f.mu.Lock()
+				if f.pgid != 0 {
+					f.signalLocked(syscall.SIGKILL)
+				}
+				f.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// started records the game's process group. children, if set, lists the
+// other processes ccdpin waits for. A terminating signal that raced with the
+// launch is delivered now.
+func (f *forwarder) started(pgid int, children func() []int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pgid = pgid
+	f.children = children
+	if f.stopping != nil {
+		f.signalLocked(f.stopping.(syscall.Signal))
+		f.armLocked()
+	}
+}
+
+// stopped forgets the process group once the game and the descendants
+// ccdpin waits for have been reaped, so a late signal cannot hit a recycled
+// group ID.
+func (f *forwarder) stopped() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.kill != nil {
+		f.kill.Stop()
+	}
+	if f.done != nil {
+		close(f.done)
+		f.done = nil
+	}
+	f.pgid = 0
+	f.children = nil
+}
+
+// interrupted returns the terminating signal that cancelled the launch, if
+// any.
+func (f *forwarder) interrupted() os.Signal {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.stopping
+}
+
+// inForeground reports whether fd is a terminal whose foreground process
+// group is ccdpin's.
+func inForeground(fd int) bool {
+	var pgrp int32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TIOCGPGRP, uintptr(unsafe.Pointer(&pgrp)))
+	return errno == 0 && int(pgrp) == syscall.Getpgrp()
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// trapScript reports each signal it traps on stdout and otherwise keeps
+// running, like a game that shuts down slowly.
+const trapScript = `for s in HUP INT TERM USR1; do trap "echo $s" $s; done; echo ready; while :; do sleep 0.05; done`
+
+// game is a child in its own process group, as runCmd starts the game.
+type game struct {
+	t     *testing.T
+	cmd   *exec.Cmd
+	lines chan string
+	done  chan error
+}
+
+func startGame(t *testing.T, script string) *game {
+	t.Helper()
+	c := exec.Command("sh", "-c", script)
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	stdout, err := c.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Start(); err != nil {
+		t.Skipf("start sh: %v", err)
+	}
+	g := &game{t: t, cmd: c, lines: make(chan string, 16), done: make(chan error, 1)}
+	go func() {
+		sc := bufio.NewScanner(stdout)
+		for sc.Scan() {
+			g.lines <- sc.Text()
+		}
+		g.done <- c.Wait()
+	}()
+	t.Cleanup(func() {
+		_ = syscall.Kill(-c.Process.Pid, syscall.SIGKILL)
+		<-g.done
+	})
+	g.expect("ready")
+	return g
+}
+
+func (g *game) pid() int { return g.cmd.Process.Pid }
+
+// expect waits for the next line of output.
+func (g *game) expect(line string) {
+	g.t.Helper()
+	select {
+	case l := <-g.lines:
+		if l != line {
+			g.t.Fatalf("game printed %q, want %q", l, line)
+		}
+	case <-time.After(5 * time.Second):
+		g.t.Fatalf("timed out waiting for %q", line)
+	}
+}
+
+// exited waits up to d for the game to exit and returns its signal, or 0
+// if it is still running.
+func (g *game) exited(d time.Duration) syscall.Signal {
+	g.t.Helper()
+	select {
+	case err := <-g.done:
+		g.done <- err
+		var ee *exec.ExitError
+		if errors.As(err, &ee) {
+			if ws, ok := ee.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+				return ws.Signal()
+			}
+		}
+		g.t.Fatalf("game exited without a signal: %v", err)
+	case <-time.After(d):
+	}
+	return 0
+}
+
+func TestForwarderSignalBeforeStartCancels(t *testing.T) {
+	cancelled := false
+	f := newForwarder(time.Hour, func() { cancelled = true })
+
+	f.handle(syscall.SIGUSR1)
+	if cancelled || f.interrupted() != nil {
+		t.Fatalf("non-terminating signal cancelled the launch")
+	}
+	f.handle(syscall.SIGTERM)
+	if !cancelled || f.interrupted() != syscall.SIGTERM {
+		t.Fatalf("SIGTERM before start: cancelled=%v interrupted=%v", cancelled, f.interrupted())
+	}
+
+	// A game started despite the race gets the signal on start.
+	g := startGame(t, "echo ready; exec sleep 30")
+	f.started(g.pid(), nil)
+	if sig := g.exited(5 * time.Second); sig != syscall.SIGTERM {
+		t.Fatalf("game exited with %v, want SIGTERM", sig)
+	}
+}
+
+func TestForwarderForwardsAndEscalates(t *testing.T) {
+	f := newForwarder(time.Hour, func() {})
+	g := startGame(t, trapScript)
+	f.started(g.pid(), nil)
+
+	f.handle(syscall.SIGUSR1)
+	g.expect("USR1")
+	f.handle(syscall.SIGTERM)
+	g.expect("TERM")
+	if sig := g.exited(200 * time.Millisecond); sig != 0 {
+		t.Fatalf("game killed by %v within the grace", sig)
+	}
+
+	f.handle(syscall.SIGINT)
+	if sig := g.exited(5 * time.Second); sig != syscall.SIGKILL {
+		t.Fatalf("second terminating signal: game exited with %v, want SIGKILL", sig)
+	}
+}
+
+func TestForwarderGraceKills(t *testing.T) {
+	f := newForwarder(100*time.Millisecond, func() {})
+	g := startGame(t, trapScript)
+	f.started(g.pid(), nil)
+
+	start := time.Now()
+	f.handle(syscall.SIGHUP)
+	g.expect("HUP")
+	if sig := g.exited(5 * time.Second); sig != syscall.SIGKILL {
+		t.Fatalf("game exited with %v, want SIGKILL", sig)
+	}
+	if waited := time.Since(start); waited < 100*time.Millisecond {
+		t.Fatalf("killed after %v, before the grace", waited)
+	}
+}
+
+func TestForwarderStoppedDisarmsTimer(t *testing.T) {
+	f := newForwarder(250*time.Millisecond, func() {})
+	g := startGame(t, trapScript)
+	f.started(g.pid(), nil)
+
+	f.handle(syscall.SIGTERM)
+	g.expect("TERM")
+	// The game was reaped; its group ID could now belong to someone else.
+	f.stopped()
+	if sig := g.exited(500 * time.Millisecond); sig != 0 {
+		t.Fatalf("timer fired after stopped: game exited with %v", sig)
+	}
+	f.handle(syscall.SIGTERM)
+	if sig := g.exited(100 * time.Millisecond); sig != 0 {
+		t.Fatalf("signal forwarded after stopped: game exited with %v", sig)
+	}
+}
+
+func TestForwarderReachesOtherChildren(t *testing.T) {
+	f := newForwarder(100*time.Millisecond, func() {})
+	g := startGame(t, trapScript)
+	// A descendant that moved to its own process group, as after setsid.
+	orphan := startGame(t, trapScript)
+	f.started(g.pid(), func() []int { return []int{g.pid(), orphan.pid()} })
+	defer f.stopped()
+
+	f.handle(syscall.SIGTERM)
+	g.expect("TERM")
+	orphan.expect("TERM")
+	for _, c := range []*game{g, orphan} {
+		if sig := c.exited(5 * time.Second); sig != syscall.SIGKILL {
+			t.Fatalf("pid %d exited with %v, want SIGKILL", c.pid(), sig)
+		}
+	}
+}
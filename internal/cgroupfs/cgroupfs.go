@@ -0,0 +1,355 @@
+// Package cgroupfs manages game and OS cpusets by writing a delegated cgroup v2
+// subtree directly, for systems where the systemd user manager is missing or
+// does not delegate the cpuset controller.
+package cgroupfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/systemdctl"
+)
+
+const mountPoint = "/sys/fs/cgroup"
+
+// Backend maps systemd-style unit names onto directories below Root:
+// slices live at Root/<slice>, scopes at Root/<slice>/<scope>.
+type Backend struct {
+	Root   string
+	DryRun bool
+}
+
+var _ systemdctl.Backend = (*Backend)(nil)
+
+// New returns a backend rooted at root. An empty root selects DefaultRoot.
+func New(root string, dryRun bool) (*Backend, error) {
+	root = strings.TrimSpace(root)
+	if root == "" {
+		r, err := DefaultRoot()
+		if err != nil {
+			return nil, err
+		}
+		root = r
+	}
+	if !filepath.IsAbs(root) {
+		root = filepath.Join(mountPoint, root)
+	}
+	fi, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("cgroup root %s is not a directory", root)
+	}
+	return &Backend{Root: root, DryRun: dryRun}, nil
+}
+
+// DefaultRoot returns the cgroup of the user's systemd manager,
+// user@<uid>.service, found by walking up from the calling process's cgroup.
+// Its children are the app.slice, background.slice and session.slice the
+// daemon pins. Outside a user manager there is no safe guess, so cgroup_root
+// must be set.
+func DefaultRoot() (string, error) {
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	return rootFromCgroup(string(data), os.Getuid())
+}
+
+func rootFromCgroup(procCgroup string, uid int) (string, error) {
+	manager := fmt.Sprintf("user@%d.service", uid)
+	for _, line := range strings.Split(procCgroup, "\n") {
+		rest, ok := strings.CutPrefix(line, "0::")
+		if !ok {
+			continue
+		}
+		self := strings.TrimSpace(rest)
+		for dir := self; dir != "/" && dir != "."; dir = filepath.Dir(dir) {
+			if filepath.Base(dir) == manager {
+				return filepath.Join(mountPoint, dir), nil
+			}
+		}
+		return "", fmt.Errorf("cgroup %s is not below %s; set cgroup_root", self, manager)
+	}
+	return "", errors.New("no cgroup v2 entry in /proc/self/cgroup")
+}
+
+func (b *Backend) Name() string { return "cgroupfs" }
+
+func (b *Backend) Close() error { return nil }
+
+// CheckCPUSet verifies that the cpuset controller is available to Root.
+func (b *Backend) CheckCPUSet() error {
+	ok, err := hasController(filepath.Join(b.Root, "cgroup.controllers"), "cpuset")
+	if err != nil {
+		return fmt.Errorf("read controllers of %s: %w", b.Root, err)
+	}
+	if !ok {
+		return fmt.Errorf("cpuset controller is not delegated to %s", b.Root)
+	}
+	return nil
+}
+
+func (b *Backend) GetAllowedCPUs(_ context.Context, unit string) (string, error) {
+	dir, err := b.unitDir(unit)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "cpuset.cpus"))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// SetAllowedCPUs writes cpuset.cpus. An empty list resets the cgroup to
+// inherit its parent's CPUs, matching AllowedCPUs= in systemd.
+func (b *Backend) SetAllowedCPUs(_ context.Context, unit string, cpus string) error {
+	dir, err := b.unitDir(unit)
+	if err != nil {
+		return err
+	}
+	if err := b.enableCPUSet(filepath.Dir(dir)); err != nil {
+		return err
+	}
+	return b.write(filepath.Join(dir, "cpuset.cpus"), cpus+"\n")
+}
+
+// StartUnit creates the cgroup for a slice below Root.
+func (b *Backend) StartUnit(_ context.Context, unit string) error {
+	if !strings.HasSuffix(unit, ".slice") {
+		return fmt.Errorf("cgroupfs can only start slices: %q", unit)
+	}
+	if err := b.enableCPUSet(b.Root); err != nil {
+		return err
+	}
+	return b.mkdir(filepath.Join(b.Root, unit))
+}
+
+// StopUnit kills any remaining members and removes the cgroup.
+func (b *Backend) StopUnit(_ context.Context, unit string) error {
+	dir, err := b.unitDir(unit)
+	if err != nil {
+		return err
+	}
+	if b.DryRun {
+		log.Printf("dry-run: rmdir %s", dir)
+		return nil
+	}
+	if pids, _ := readPIDs(filepath.Join(dir, "cgroup.procs")); len(pids) > 0 {
+		if err := os.WriteFile(filepath.Join(dir, "cgroup.kill"), []byte("1\n"), 0o644); err != nil {
+			return fmt.Errorf("kill %s: %w", unit, err)
+		}
+	}
+	var lastErr error
+	for i := 0; i < 20; i++ {
+		if lastErr = os.Remove(dir); lastErr == nil || errors.Is(lastErr, os.ErrNotExist) {
+			return nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return fmt.Errorf("remove %s: %w", unit, lastErr)
+}
+
+// EnsureTransientScope creates Root/<slice>/<scope> (if missing) and moves
+// PIDs into it. Like the systemd backend it reports created=false without
+// attaching anything when the scope already exists.
+func (b *Backend) EnsureTransientScope(_ context.Context, scopeName string, pids []int, slice string, _ string) (bool, error) {
+	if !strings.HasSuffix(scopeName, ".scope") {
+		return false, fmt.Errorf("scope name must end with .scope: %q", scopeName)
+	}
+	if strings.TrimSpace(slice) == "" {
+		slice = "game.slice"
+	}
+	if _, err := b.unitDir(scopeName); err == nil {
+		return false, nil
+	}
+	sliceDir := filepath.Join(b.Root, slice)
+	if err := b.enableCPUSet(b.Root); err != nil {
+		return false, err
+	}
+	if err := b.mkdir(sliceDir); err != nil {
+		return false, err
+	}
+	if err := b.enableCPUSet(sliceDir); err != nil {
+		return false, err
+	}
+	dir := filepath.Join(sliceDir, scopeName)
+	if err := b.mkdir(dir); err != nil {
+		return false, err
+	}
+	return true, b.movePIDs(dir, pids)
+}
+
+func (b *Backend) AttachProcessesToUnit(_ context.Context, unit string, subcgroup string, pids []int) error {
+	if len(pids) == 0 {
+		return nil
+	}
+	dir, err := b.unitDir(unit)
+	if err != nil {
+		return err
+	}
+	if subcgroup = strings.Trim(subcgroup, "/"); subcgroup != "" {
+		dir = filepath.Join(dir, subcgroup)
+	}
+	return b.movePIDs(dir, pids)
+}
+
+// ListUnitsByPatterns matches unit directories at Root and one level below.
+// ActiveState is always "active"; SubState is "running" while the cgroup has
+// members and "empty" otherwise.
+func (b *Backend) ListUnitsByPatterns(_ context.Context, states []string, patterns []string) ([]systemdctl.UnitStatus, error) {
+	var out []systemdctl.UnitStatus
+	seen := map[string]struct{}{}
+	for _, pattern := range patterns {
+		for _, glob := range []string{filepath.Join(b.Root, pattern), filepath.Join(b.Root, "*", pattern)} {
+			matches, err := filepath.Glob(glob)
+			if err != nil {
+				return nil, err
+			}
+			for _, dir := range matches {
+				name := filepath.Base(dir)
+				if _, ok := seen[name]; ok {
+					continue
+				}
+				if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+					continue
+				}
+				seen[name] = struct{}{}
+				sub := "empty"
+				if pids, _ := readPIDs(filepath.Join(dir, "cgroup.procs")); len(pids) > 0 {
+					sub = "running"
+				}
+				u := systemdctl.UnitStatus{Name: name, LoadState: "loaded", ActiveState: "active", SubState: sub}
+				if len(states) > 0 && !containsString(states, u.ActiveState) && !containsString(states, u.SubState) {
+					continue
+				}
+				out = append(out, u)
+			}
+		}
+	}
+	return out, nil
+}
+
+func (b *Backend) GetUnitProcesses(_ context.Context, unit string) ([]systemdctl.UnitProcess, error) {
+	dir, err := b.unitDir(unit)
+	if err != nil {
+		return nil, err
+	}
+	pids, err := readPIDs(filepath.Join(dir, "cgroup.procs"))
+	if err != nil {
+		return nil, err
+	}
+	rel := strings.TrimPrefix(dir, mountPoint)
+	out := make([]systemdctl.UnitProcess, 0, len(pids))
+	for _, pid := range pids {
+		out = append(out, systemdctl.UnitProcess{Cgroup: rel, PID: uint32(pid)})
+	}
+	return out, nil
+}
+
+// unitDir locates the directory for unit at Root or one level below it.
+func (b *Backend) unitDir(unit string) (string, error) {
+	if unit == "" || strings.ContainsRune(unit, '/') {
+		return "", fmt.Errorf("invalid unit name %q", unit)
+	}
+	direct := filepath.Join(b.Root, unit)
+	if fi, err := os.Stat(direct); err == nil && fi.IsDir() {
+		return direct, nil
+	}
+	matches, err := filepath.Glob(filepath.Join(b.Root, "*", unit))
+	if err != nil {
+		return "", err
+	}
+	for _, m := range matches {
+		if fi, err := os.Stat(m); err == nil && fi.IsDir() {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("unit %s: %w", unit, os.ErrNotExist)
+}
+
+// enableCPUSet turns on the cpuset controller for dir's children.
+func (b *Backend) enableCPUSet(dir string) error {
+	if ok, err := hasController(filepath.Join(dir, "cgroup.subtree_control"), "cpuset"); err == nil && ok {
+		return nil
+	}
+	if err := b.write(filepath.Join(dir, "cgroup.subtree_control"), "+cpuset\n"); err != nil {
+		return fmt.Errorf("enable cpuset in %s: %w", dir, err)
+	}
+	return nil
+}
+
+func (b *Backend) movePIDs(dir string, pids []int) error {
+	procs := filepath.Join(dir, "cgroup.procs")
+	for _, pid := range pids {
+		if pid <= 0 {
+			continue
+		}
+		// The kernel accepts exactly one PID per write.
+		if err := b.write(procs, strconv.Itoa(pid)+"\n"); err != nil {
+			return fmt.Errorf("move pid %d: %w", pid, err)
+		}
+	}
+	return nil
+}
+
+func (b *Backend) mkdir(dir string) error {
+	if b.DryRun {
+		log.Printf("dry-run: mkdir %s", dir)
+		return nil
+	}
+	if err := os.Mkdir(dir, 0o755); err != nil && !errors.Is(err, os.ErrExist) {
+		return err
+	}
+	return nil
+}
+
+func (b *Backend) write(path string, value string) error {
+	if b.DryRun {
+		log.Printf("dry-run: write %s = %q", path, strings.TrimSpace(value))
+		return nil
+	}
+	return os.WriteFile(path, []byte(value), 0o644)
+}
+
+func hasController(path string, name string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	return containsString(strings.Fields(string(data)), name), nil
+}
+
+func readPIDs(path string) ([]int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var out []int
+	for _, f := range strings.Fields(string(data)) {
+		pid, err := strconv.Atoi(f)
+		if err != nil || pid <= 0 {
+			continue
+		}
+		out = append(out, pid)
+	}
+	return out, nil
+}
+
+func containsString(list []string, item string) bool {
+	for _, s := range list {
+		if s == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package cgroupfs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEnsureScopeAndAllowedCPUs(t *testing.T) {
+	root := t.TempDir()
+	b := &Backend{Root: root}
+	ctx := context.Background()
+
+	created, err := b.EnsureTransientScope(ctx, "game-42.scope", []int{1234}, "game.slice", "")
+	if err != nil {
+		t.Fatalf("EnsureTransientScope: %v", err)
+	}
+	if !created {
+		t.Fatalf("expected scope to be created")
+	}
+	procs, err := os.ReadFile(filepath.Join(root, "game.slice", "game-42.scope", "cgroup.procs"))
+	if err != nil {
+		t.Fatalf("read cgroup.procs: %v", err)
+	}
+	if strings.TrimSpace(string(procs)) != "1234" {
+		t.Fatalf("unexpected cgroup.procs: %q", procs)
+	}
+	ctl, err := os.ReadFile(filepath.Join(root, "game.slice", "cgroup.subtree_control"))
+	if err != nil || !strings.Contains(string(ctl), "cpuset") {
+		t.Fatalf("expected cpuset enabled on game.slice: %q %v", ctl, err)
+	}
+
+	created, err = b.EnsureTransientScope(ctx, "game-42.scope", []int{1234}, "game.slice", "")
+	if err != nil || created {
+		t.Fatalf("expected existing scope: created=%v err=%v", created, err)
+	}
+
+	if err := b.SetAllowedCPUs(ctx, "game-42.scope", "8-15"); err != nil {
+		t.Fatalf("SetAllowedCPUs: %v", err)
+	}
+	got, err := b.GetAllowedCPUs(ctx, "game-42.scope")
+	if err != nil {
+		t.Fatalf("GetAllowedCPUs: %v", err)
+	}
+	if got != "8-15" {
+		t.Fatalf("unexpected cpus: %q", got)
+	}
+}
+
+func TestListUnitsByPatterns(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"app.slice", "game.slice/game-1.scope", "game.slice/game-2.scope"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "game.slice", "game-1.scope", "cgroup.procs"), []byte("77\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	b := &Backend{Root: root}
+	units, err := b.ListUnitsByPatterns(context.Background(), nil, []string{"game-*.scope"})
+	if err != nil {
+		t.Fatalf("ListUnitsByPatterns: %v", err)
+	}
+	if len(units) != 2 {
+		t.Fatalf("unexpected units: %#v", units)
+	}
+	if units[0].Name != "game-1.scope" || units[0].SubState != "running" {
+		t.Fatalf("unexpected first unit: %#v", units[0])
+	}
+	if units[1].SubState != "empty" {
+		t.Fatalf("unexpected second unit: %#v", units[1])
+	}
+}
+
+func TestCheckCPUSet(t *testing.T) {
+	root := t.TempDir()
+	b := &Backend{Root: root}
+	if err := os.WriteFile(filepath.Join(root, "cgroup.controllers"), []byte("cpu io memory pids\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := b.CheckCPUSet(); err == nil {
+		t.Fatalf("expected missing cpuset to be reported")
+	}
+	if err := os.WriteFile(filepath.Join(root, "cgroup.controllers"), []byte("cpuset cpu io memory pids\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := b.CheckCPUSet(); err != nil {
+		t.Fatalf("CheckCPUSet: %v", err)
+	}
+}
+
+func TestRootFromCgroup(t *testing.T) {
+	tests := []struct {
+		cgroup  string
+		want    string
+		wantErr bool
+	}{
+		// The daemon as a user service sits in app.slice.
+		{cgroup: "0::/user.slice/user-1000.slice/user@1000.service/app.slice/ccdbind.service\n", want: "/sys/fs/cgroup/user.slice/user-1000.slice/user@1000.service"},
+		{cgroup: "0::/user.slice/user-1000.slice/user@1000.service/session.slice/ccdbind.service", want: "/sys/fs/cgroup/user.slice/user-1000.slice/user@1000.service"},
+		// Another user's manager is not ours.
+		{cgroup: "0::/user.slice/user-1001.slice/user@1001.service/app.slice/x.service", wantErr: true},
+		// A login session scope is outside the user manager.
+		{cgroup: "0::/user.slice/user-1000.slice/session-2.scope", wantErr: true},
+		{cgroup: "12:cpuset:/\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := rootFromCgroup(tt.cgroup, 1000)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("rootFromCgroup(%q) = %q, %v; want %q, err=%v", tt.cgroup, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -21,8 +21,58 @@ type Config struct {
 	PinSlices        []string
 	OSCPUsOverride   string
 	GameCPUsOverride string
+	Backend          string
+	CgroupRoot       string
+	Mode             string
+	Games            map[string]GameProfile
+	Hooks            Hooks
+
+	// StartDelay is how long a game must be seen before it is placed and the
+	// slices are pinned; StopGrace is how long the pin is held after the
+	// game's last process exits.
+	StartDelay time.Duration
+	StopGrace  time.Duration
+
+	// MetricsListen is a host:port, or unix:<path>, to serve Prometheus
+	// metrics on. Empty disables the exporter.
+	MetricsListen string
+
+	// RecoverCcdpin makes the daemon restore slices left pinned by ccdpin
+	// instances that died without releasing them, once at startup.
+	RecoverCcdpin bool
+}
+
+// GameProfile holds per-game overrides, keyed by game ID (e.g. SteamAppId).
+type GameProfile struct {
+	// Name is a human-readable label passed to hooks as CCDBIND_GAME_NAME.
+	Name string
+	Mode string
+
+	// OSCPUs, GameCPUs and PinSlices override the global settings for
+	// ccdpin launches of this game. The daemon pins one set of slices for
+	// all games and ignores them.
+	OSCPUs    string
+	GameCPUs  string
+	PinSlices []string
 }
 
+// Hooks are shell commands the daemon runs (via sh -c) on state transitions.
+// Empty commands are skipped.
+type Hooks struct {
+	OnGameStart  string
+	OnGameStop   string
+	OnPinApplied string
+	OnRestore    string
+	Timeout      time.Duration
+}
+
+const (
+	// ModeScope moves game PIDs into a game-<id>.scope pinned to GAME CPUs.
+	ModeScope = "scope"
+	// ModeAffinity leaves cgroups alone and sets per-thread CPU affinity.
+	ModeAffinity = "affinity"
+)
+
 type tomlConfig struct {
 	Interval         string   `toml:"interval"`
 	EnvKeys          []string `toml:"env_keys"`
@@ -33,6 +83,32 @@ type tomlConfig struct {
 	PinSlices        []string `toml:"pin_slices"`
 	OSCPUsOverride   string   `toml:"os_cpus"`
 	GameCPUsOverride string   `toml:"game_cpus"`
+	Backend          string   `toml:"backend"`
+	CgroupRoot       string   `toml:"cgroup_root"`
+	Mode             string   `toml:"mode"`
+	StartDelay       string   `toml:"start_delay"`
+	StopGrace        string   `toml:"stop_grace"`
+	MetricsListen    string   `toml:"metrics_listen"`
+	RecoverCcdpin    *bool    `toml:"recover_ccdpin"`
+
+	Games map[string]tomlGame `toml:"games"`
+	Hooks tomlHooks           `toml:"hooks"`
+}
+
+type tomlGame struct {
+	Name      string   `toml:"name"`
+	Mode      string   `toml:"mode"`
+	OSCPUs    string   `toml:"os_cpus"`
+	GameCPUs  string   `toml:"game_cpus"`
+	PinSlices []string `toml:"pin_slices"`
+}
+
+type tomlHooks struct {
+	OnGameStart  string `toml:"on_game_start"`
+	OnGameStop   string `toml:"on_game_stop"`
+	OnPinApplied string `toml:"on_pin_applied"`
+	OnRestore    string `toml:"on_restore"`
+	Timeout      string `toml:"timeout"`
 }
 
 func Default() Config {
@@ -73,6 +149,12 @@ func Default() Config {
 			"app.slice",
 			"background.slice",
 		},
+		Backend:       "systemd",
+		Mode:          ModeScope,
+		RecoverCcdpin: true,
+		Hooks: Hooks{
+			Timeout: 10 * time.Second,
+		},
 	}
 }
 
@@ -136,6 +218,9 @@ func Load(path string) (Config, error) {
 			if tc.PinSessionSlice != nil {
 				cfg.PinSessionSlice = *tc.PinSessionSlice
 			}
+			if tc.RecoverCcdpin != nil {
+				cfg.RecoverCcdpin = *tc.RecoverCcdpin
+			}
 			if len(tc.PinSlices) > 0 {
 				cfg.PinSlices = dedupeNonEmpty(tc.PinSlices, nil)
 			}
@@ -145,6 +230,75 @@ func Load(path string) (Config, error) {
 			if tc.GameCPUsOverride != "" {
 				cfg.GameCPUsOverride = strings.TrimSpace(tc.GameCPUsOverride)
 			}
+			if tc.Backend != "" {
+				cfg.Backend = strings.ToLower(strings.TrimSpace(tc.Backend))
+				if cfg.Backend != "systemd" && cfg.Backend != "cgroupfs" {
+					return Config{}, fmt.Errorf("invalid backend %q (expected systemd|cgroupfs)", tc.Backend)
+				}
+			}
+			if tc.CgroupRoot != "" {
+				cfg.CgroupRoot = strings.TrimSpace(tc.CgroupRoot)
+			}
+			if tc.Mode != "" {
+				mode, err := parseMode(tc.Mode)
+				if err != nil {
+					return Config{}, err
+				}
+				cfg.Mode = mode
+			}
+			if tc.StartDelay != "" {
+				d, err := time.ParseDuration(tc.StartDelay)
+				if err != nil || d < 0 {
+					return Config{}, fmt.Errorf("invalid start_delay %q", tc.StartDelay)
+				}
+				cfg.StartDelay = d
+			}
+			if tc.StopGrace != "" {
+				d, err := time.ParseDuration(tc.StopGrace)
+				if err != nil || d < 0 {
+					return Config{}, fmt.Errorf("invalid stop_grace %q", tc.StopGrace)
+				}
+				cfg.StopGrace = d
+			}
+			if tc.MetricsListen != "" {
+				cfg.MetricsListen = strings.TrimSpace(tc.MetricsListen)
+			}
+			for id, g := range tc.Games {
+				id = strings.TrimSpace(id)
+				if id == "" {
+					continue
+				}
+				p := GameProfile{
+					Name:     strings.TrimSpace(g.Name),
+					OSCPUs:   strings.TrimSpace(g.OSCPUs),
+					GameCPUs: strings.TrimSpace(g.GameCPUs),
+				}
+				if len(g.PinSlices) > 0 {
+					p.PinSlices = dedupeNonEmpty(g.PinSlices, nil)
+				}
+				if g.Mode != "" {
+					mode, err := parseMode(g.Mode)
+					if err != nil {
+						return Config{}, fmt.Errorf("games.%s: %w", id, err)
+					}
+					p.Mode = mode
+				}
+				if cfg.Games == nil {
+					cfg.Games = map[string]GameProfile{}
+				}
+				cfg.Games[id] = p
+			}
+			cfg.Hooks.OnGameStart = strings.TrimSpace(tc.Hooks.OnGameStart)
+			cfg.Hooks.OnGameStop = strings.TrimSpace(tc.Hooks.OnGameStop)
+			cfg.Hooks.OnPinApplied = strings.TrimSpace(tc.Hooks.OnPinApplied)
+			cfg.Hooks.OnRestore = strings.TrimSpace(tc.Hooks.OnRestore)
+			if tc.Hooks.Timeout != "" {
+				d, err := time.ParseDuration(tc.Hooks.Timeout)
+				if err != nil || d <= 0 {
+					return Config{}, fmt.Errorf("invalid hooks.timeout %q", tc.Hooks.Timeout)
+				}
+				cfg.Hooks.Timeout = d
+			}
 		}
 	}
 
@@ -166,6 +320,42 @@ func Load(path string) (Config, error) {
 	return cfg, nil
 }
 
+// ModeFor returns the placement mode for gameID: its profile's mode if set,
+// otherwise the global mode.
+func (c Config) ModeFor(gameID string) string {
+	if p, ok := c.Games[gameID]; ok && p.Mode != "" {
+		return p.Mode
+	}
+	if c.Mode == "" {
+		return ModeScope
+	}
+	return c.Mode
+}
+
+// OSSlices returns the slices to pin to the OS CPUs: pin_slices plus
+// session.slice if pin_session_slice is set, or app.slice and
+// background.slice if that leaves nothing.
+func (c Config) OSSlices() []string {
+	slices := append([]string{}, c.PinSlices...)
+	if c.PinSessionSlice {
+		slices = append(slices, "session.slice")
+	}
+	slices = dedupeNonEmpty(slices, nil)
+	if len(slices) == 0 {
+		return []string{"app.slice", "background.slice"}
+	}
+	return slices
+}
+
+func parseMode(v string) (string, error) {
+	switch mode := strings.ToLower(strings.TrimSpace(v)); mode {
+	case ModeScope, ModeAffinity:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid mode %q (expected scope|affinity)", v)
+	}
+}
+
 func dedupeNonEmpty(in []string, transform func(string) string) []string {
 	seen := make(map[string]struct{}, len(in))
 	out := make([]string, 0, len(in))
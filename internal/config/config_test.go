@@ -38,6 +38,7 @@ pin_session_slice = true
 pin_slices = ["app.slice"]
 os_cpus = "0-7"
 game_cpus = "8-15"
+recover_ccdpin = false
 `), 0o644); err != nil {
 		t.Fatalf("WriteFile(config): %v", err)
 	}
@@ -52,6 +53,9 @@ game_cpus = "8-15"
 	if !cfg.PinSessionSlice {
 		t.Fatalf("expected PinSessionSlice=true")
 	}
+	if cfg.RecoverCcdpin {
+		t.Fatalf("expected RecoverCcdpin=false")
+	}
 	if len(cfg.PinSlices) != 1 || cfg.PinSlices[0] != "app.slice" {
 		t.Fatalf("unexpected PinSlices: %#v", cfg.PinSlices)
 	}
@@ -66,6 +70,122 @@ game_cpus = "8-15"
 	}
 }
 
+func TestLoad_Backend(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+
+	path := filepath.Join(dir, "config.toml")
+	if err := os.WriteFile(path, []byte("backend = \"CgroupFS\"\ncgroup_root = \"/sys/fs/cgroup/gaming\"\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile(config): %v", err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if cfg.Backend != "cgroupfs" || cfg.CgroupRoot != "/sys/fs/cgroup/gaming" {
+		t.Fatalf("unexpected backend: %q root=%q", cfg.Backend, cfg.CgroupRoot)
+	}
+
+	if err := os.WriteFile(path, []byte("backend = \"runit\"\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile(config): %v", err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Fatalf("expected invalid backend to be rejected")
+	}
+}
+
+func TestLoad_GameModes(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+
+	path := filepath.Join(dir, "config.toml")
+	if err := os.WriteFile(path, []byte(`mode = "scope"
+
+[games."570"]
+mode = "Affinity"
+`), 0o644); err != nil {
+		t.Fatalf("WriteFile(config): %v", err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if got := cfg.ModeFor("570"); got != ModeAffinity {
+		t.Fatalf("expected affinity for 570, got %q", got)
+	}
+	if got := cfg.ModeFor("730"); got != ModeScope {
+		t.Fatalf("expected scope for 730, got %q", got)
+	}
+
+	if err := os.WriteFile(path, []byte("[games.\"570\"]\nmode = \"taskset\"\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile(config): %v", err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Fatalf("expected invalid mode to be rejected")
+	}
+}
+
+func TestLoad_Hooks(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+
+	path := filepath.Join(dir, "config.toml")
+	if err := os.WriteFile(path, []byte(`[hooks]
+on_game_start = "powerprofilesctl set performance"
+on_restore = "  powerprofilesctl set balanced  "
+timeout = "3s"
+
+[games."570"]
+name = "Dota 2"
+`), 0o644); err != nil {
+		t.Fatalf("WriteFile(config): %v", err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if cfg.Hooks.OnGameStart != "powerprofilesctl set performance" || cfg.Hooks.OnRestore != "powerprofilesctl set balanced" {
+		t.Fatalf("unexpected hooks: %#v", cfg.Hooks)
+	}
+	if cfg.Hooks.OnGameStop != "" || cfg.Hooks.Timeout.String() != "3s" {
+		t.Fatalf("unexpected hooks: %#v", cfg.Hooks)
+	}
+	if cfg.Games["570"].Name != "Dota 2" {
+		t.Fatalf("unexpected profile: %#v", cfg.Games["570"])
+	}
+
+	if err := os.WriteFile(path, []byte("[hooks]\ntimeout = \"-1s\"\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile(config): %v", err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Fatalf("expected invalid hooks.timeout to be rejected")
+	}
+}
+
+func TestLoad_Debounce(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+
+	path := filepath.Join(dir, "config.toml")
+	if err := os.WriteFile(path, []byte("start_delay = \"5s\"\nstop_grace = \"1m\"\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile(config): %v", err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if cfg.StartDelay.String() != "5s" || cfg.StopGrace.String() != "1m0s" {
+		t.Fatalf("unexpected delays: start=%s stop=%s", cfg.StartDelay, cfg.StopGrace)
+	}
+
+	if err := os.WriteFile(path, []byte("stop_grace = \"soon\"\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile(config): %v", err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Fatalf("expected invalid stop_grace to be rejected")
+	}
+}
+
 func TestLoad_IgnoreFileWithoutConfig(t *testing.T) {
 	dir := t.TempDir()
 	t.Setenv("XDG_CONFIG_HOME", dir)
@@ -96,3 +216,37 @@ func contains(list []string, item string) bool {
 	}
 	return false
 }
+
+func TestLoad_GameProfileCPUs(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+
+	path := filepath.Join(dir, "config.toml")
+	if err := os.WriteFile(path, []byte(`pin_slices = ["app.slice"]
+pin_session_slice = true
+
+[games."570"]
+os_cpus = " 0-3 "
+game_cpus = "4-15"
+pin_slices = ["background.slice", "background.slice"]
+`), 0o644); err != nil {
+		t.Fatalf("WriteFile(config): %v", err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	p := cfg.Games["570"]
+	if p.OSCPUs != "0-3" || p.GameCPUs != "4-15" {
+		t.Fatalf("unexpected profile cpus: %#v", p)
+	}
+	if len(p.PinSlices) != 1 || p.PinSlices[0] != "background.slice" {
+		t.Fatalf("unexpected profile slices: %#v", p.PinSlices)
+	}
+	if got := cfg.OSSlices(); len(got) != 2 || got[0] != "app.slice" || got[1] != "session.slice" {
+		t.Fatalf("unexpected OSSlices: %#v", got)
+	}
+	if got := Default().OSSlices(); len(got) != 2 || got[0] != "app.slice" || got[1] != "background.slice" {
+		t.Fatalf("unexpected default OSSlices: %#v", got)
+	}
+}
@@ -0,0 +1,232 @@
+// Package control defines the daemon's D-Bus API on the user bus and a small
+// client for it. Structured replies are JSON strings so the schema can grow
+// without changing method signatures.
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+
+	"github.com/Reidond/ccdbind/internal/history"
+	"github.com/Reidond/ccdbind/internal/systemdctl"
+)
+
+const (
+	BusName    = "io.github.Reidond.ccdbind"
+	ObjectPath = dbus.ObjectPath("/io/github/Reidond/ccdbind")
+	Interface  = "io.github.Reidond.ccdbind1"
+
+	SignalGameStarted = Interface + ".GameStarted"
+	SignalGameStopped = Interface + ".GameStopped"
+)
+
+// ErrNotRunning is returned by Dial when no daemon owns BusName.
+var ErrNotRunning = errors.New("ccdbind daemon is not running")
+
+// Status is the daemon's in-memory view, as returned by GetStatus.
+type Status struct {
+	PID       int       `json:"pid"`
+	StartedAt time.Time `json:"started_at"`
+	Backend   string    `json:"backend"`
+	DryRun    bool      `json:"dry_run"`
+
+	OSCPUs     string `json:"os_cpus"`
+	GameCPUs   string `json:"game_cpus"`
+	PinApplied bool   `json:"pin_applied"`
+
+	Paused      bool      `json:"paused"`
+	PausedUntil time.Time `json:"paused_until"`
+
+	LastTick       time.Time `json:"last_tick"`
+	LastTickMillis float64   `json:"last_tick_ms"`
+	LastError      string    `json:"last_error,omitempty"`
+	LastErrorAt    time.Time `json:"last_error_at"`
+
+	Games   []Game    `json:"games"`
+	Pending []Pending `json:"pending,omitempty"`
+
+	// Launchers are ccdpin instances that registered with the daemon.
+	// SlicesHeldByCcdpin is set while ccdpin instances that started without
+	// the daemon own the slice pin; the daemon leaves the slices alone then.
+	Launchers          []Launcher `json:"launchers,omitempty"`
+	SlicesHeldByCcdpin bool       `json:"slices_held_by_ccdpin,omitempty"`
+}
+
+// Launcher is a ccdpin instance that handed slice pinning to the daemon.
+type Launcher struct {
+	PID    int       `json:"pid"`
+	GameID string    `json:"game_id,omitempty"`
+	Since  time.Time `json:"since"`
+}
+
+// Pending is a game transition held back by start_delay or stop_grace.
+type Pending struct {
+	GameID string `json:"game_id"`
+	// Transition is "start" (seen, not yet placed) or "stop" (exited, pin
+	// still held).
+	Transition string    `json:"transition"`
+	Since      time.Time `json:"since"`
+	Due        time.Time `json:"due"`
+}
+
+// Game is a game the daemon is currently tracking.
+type Game struct {
+	ID        string    `json:"id"`
+	Unit      string    `json:"unit,omitempty"`
+	Mode      string    `json:"mode"`
+	StartedAt time.Time `json:"started_at"`
+	Procs     []Proc    `json:"procs"`
+
+	Placement *history.Placement `json:"placement,omitempty"`
+}
+
+// Proc is one tracked game process.
+type Proc struct {
+	PID       int    `json:"pid"`
+	StartTime uint64 `json:"start_time"`
+	Unit      string `json:"unit,omitempty"`
+	Threads   int    `json:"threads,omitempty"`
+}
+
+// Client talks to a running daemon.
+type Client struct {
+	conn *dbus.Conn
+	obj  dbus.BusObject
+}
+
+// Dial connects to the user bus and checks that the daemon is running.
+func Dial() (*Client, error) {
+	conn, err := systemdctl.ConnectUserBus()
+	if err != nil {
+		return nil, err
+	}
+	var owned bool
+	if err := conn.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0, BusName).Store(&owned); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !owned {
+		conn.Close()
+		return nil, ErrNotRunning
+	}
+	return &Client{conn: conn, obj: conn.Object(BusName, ObjectPath)}, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) GetStatus(ctx context.Context) (Status, error) {
+	var st Status
+	err := c.callJSON(ctx, "GetStatus", &st)
+	return st, err
+}
+
+func (c *Client) ListGames(ctx context.Context) ([]Game, error) {
+	var games []Game
+	err := c.callJSON(ctx, "ListGames", &games)
+	return games, err
+}
+
+// Pause stops the daemon from touching slices and scopes until t, or until
+// Resume when t is zero. On the wire a negative time means indefinitely and
+// 0 means resume.
+func (c *Client) Pause(ctx context.Context, t time.Time) error {
+	unix := int64(-1)
+	if !t.IsZero() {
+		unix = t.Unix()
+	}
+	return c.obj.CallWithContext(ctx, Interface+".PauseUntil", 0, unix).Err
+}
+
+func (c *Client) Resume(ctx context.Context) error {
+	return c.obj.CallWithContext(ctx, Interface+".PauseUntil", 0, int64(0)).Err
+}
+
+// ForceRestore restores the pinned slices to their originals right away.
+func (c *Client) ForceRestore(ctx context.Context) error {
+	return c.obj.CallWithContext(ctx, Interface+".ForceRestore", 0).Err
+}
+
+// PinPID treats pid as a game process until it exits.
+func (c *Client) PinPID(ctx context.Context, pid int) error {
+	if pid <= 0 {
+		return fmt.Errorf("invalid pid %d", pid)
+	}
+	return c.obj.CallWithContext(ctx, Interface+".PinPID", 0, uint32(pid)).Err
+}
+
+// UnpinPID drops a manual pin of pid, or excludes pid if it was detected.
+func (c *Client) UnpinPID(ctx context.Context, pid int) error {
+	if pid <= 0 {
+		return fmt.Errorf("invalid pid %d", pid)
+	}
+	return c.obj.CallWithContext(ctx, Interface+".UnpinPID", 0, uint32(pid)).Err
+}
+
+// PinGame places gameID without waiting for start_delay and lifts any
+// exclusion.
+func (c *Client) PinGame(ctx context.Context, gameID string) error {
+	return c.obj.CallWithContext(ctx, Interface+".PinGame", 0, gameID).Err
+}
+
+// UnpinGame drops a manual pin of gameID, or excludes it if it was not pinned.
+func (c *Client) UnpinGame(ctx context.Context, gameID string) error {
+	return c.obj.CallWithContext(ctx, Interface+".UnpinGame", 0, gameID).Err
+}
+
+// RegisterLauncher tells the daemon that the launcher pid is starting
+// gameID. The daemon keeps the slices pinned, and skips start_delay for
+// gameID, until pid exits or UnregisterLauncher is called.
+func (c *Client) RegisterLauncher(ctx context.Context, pid int, gameID string) error {
+	if pid <= 0 {
+		return fmt.Errorf("invalid pid %d", pid)
+	}
+	return c.obj.CallWithContext(ctx, Interface+".RegisterLauncher", 0, uint32(pid), gameID).Err
+}
+
+func (c *Client) UnregisterLauncher(ctx context.Context, pid int) error {
+	if pid <= 0 {
+		return fmt.Errorf("invalid pid %d", pid)
+	}
+	return c.obj.CallWithContext(ctx, Interface+".UnregisterLauncher", 0, uint32(pid)).Err
+}
+
+func (c *Client) ReloadConfig(ctx context.Context) error {
+	return c.obj.CallWithContext(ctx, Interface+".ReloadConfig", 0).Err
+}
+
+func (c *Client) callJSON(ctx context.Context, method string, out any) error {
+	var raw string
+	if err := c.obj.CallWithContext(ctx, Interface+"."+method, 0).Store(&raw); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(raw), out)
+}
+
+// IntrospectXML describes the interface for org.freedesktop.DBus.Introspectable.
+const IntrospectXML = `<node>
+  <interface name="` + Interface + `">
+    <method name="GetStatus"><arg name="status_json" type="s" direction="out"/></method>
+    <method name="ListGames"><arg name="games_json" type="s" direction="out"/></method>
+    <method name="PauseUntil"><arg name="unix_time" type="x" direction="in"/></method>
+    <method name="ForceRestore"/>
+    <method name="PinPID"><arg name="pid" type="u" direction="in"/></method>
+    <method name="UnpinPID"><arg name="pid" type="u" direction="in"/></method>
+    <method name="PinGame"><arg name="game_id" type="s" direction="in"/></method>
+    <method name="UnpinGame"><arg name="game_id" type="s" direction="in"/></method>
+    <method name="RegisterLauncher"><arg name="pid" type="u" direction="in"/><arg name="game_id" type="s" direction="in"/></method>
+    <method name="UnregisterLauncher"><arg name="pid" type="u" direction="in"/></method>
+    <method name="ReloadConfig"/>
+    <signal name="GameStarted"><arg name="game_id" type="s"/><arg name="unit" type="s"/></signal>
+    <signal name="GameStopped"><arg name="game_id" type="s"/><arg name="unit" type="s"/></signal>
+  </interface>
+  <interface name="org.freedesktop.DBus.Introspectable">
+    <method name="Introspect"><arg name="xml" type="s" direction="out"/></method>
+  </interface>
+</node>`
@@ -0,0 +1,32 @@
+package control
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+func TestIntrospectXMLListsMethods(t *testing.T) {
+	var node struct {
+		Interfaces []struct {
+			Name    string `xml:"name,attr"`
+			Methods []struct {
+				Name string `xml:"name,attr"`
+			} `xml:"method"`
+		} `xml:"interface"`
+	}
+	if err := xml.Unmarshal([]byte(IntrospectXML), &node); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if len(node.Interfaces) == 0 || node.Interfaces[0].Name != Interface {
+		t.Fatalf("unexpected interfaces: %#v", node.Interfaces)
+	}
+	want := map[string]bool{"GetStatus": false, "ListGames": false, "PauseUntil": false, "ForceRestore": false, "PinPID": false, "UnpinPID": false, "PinGame": false, "UnpinGame": false, "RegisterLauncher": false, "UnregisterLauncher": false, "ReloadConfig": false}
+	for _, m := range node.Interfaces[0].Methods {
+		want[m.Name] = true
+	}
+	for name, found := range want {
+		if !found {
+			t.Fatalf("method %s missing from introspection", name)
+		}
+	}
+}
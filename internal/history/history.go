@@ -0,0 +1,165 @@
+// Package history records finished game sessions as JSON lines next to the
+// state file. The log is rotated to a single ".1" backup when it grows past
+// MaxBytes.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// MaxBytes is the size at which the log is rotated.
+const MaxBytes = 4 << 20
+
+// Session is one game run as seen by the daemon.
+type Session struct {
+	GameID string    `json:"game_id"`
+	Name   string    `json:"name,omitempty"`
+	Mode   string    `json:"mode"`
+	Scope  string    `json:"scope,omitempty"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	PIDs   []int     `json:"pids"`
+
+	OSCPUs   string `json:"os_cpus"`
+	GameCPUs string `json:"game_cpus"`
+
+	// Placed is true once the game was successfully put on GAME CPUs (scope
+	// pinned or affinity set); SlicesPinned once the OS slices were pinned
+	// while it ran.
+	Placed       bool `json:"placed"`
+	SlicesPinned bool `json:"slices_pinned"`
+
+	Reapplies int        `json:"reapplies"`
+	Errors    []string   `json:"errors,omitempty"`
+	Placement *Placement `json:"placement,omitempty"`
+
+	// Interrupted marks sessions closed by daemon shutdown rather than by
+	// the game exiting.
+	Interrupted bool `json:"interrupted,omitempty"`
+}
+
+// Placement summarises where a game's threads ran, from per-tick samples of
+// /proc/<pid>/task/*/stat and the scope's cpu.stat.
+type Placement struct {
+	// Samples counts thread samples; OffGameCPU those in which the thread
+	// had last run on a CPU outside GAME CPUs.
+	Samples     int   `json:"samples"`
+	OffGameCPU  int   `json:"off_game_cpu"`
+	OffGameTIDs []int `json:"off_game_tids,omitempty"`
+	Threads     int   `json:"threads"`
+
+	// Migrations is the sum of se.nr_migrations deltas; it is only
+	// collected when /proc/<pid>/task/<tid>/sched exists.
+	Migrations      uint64 `json:"migrations"`
+	MigrationsKnown bool   `json:"migrations_known"`
+
+	CPUSeconds      float64 `json:"cpu_seconds"`
+	ScopeCPUSeconds float64 `json:"scope_cpu_seconds,omitempty"`
+}
+
+// OffGameRatio is the fraction of samples taken off GAME CPUs.
+func (p Placement) OffGameRatio() float64 {
+	if p.Samples == 0 {
+		return 0
+	}
+	return float64(p.OffGameCPU) / float64(p.Samples)
+}
+
+func (s Session) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+func DefaultPath() (string, error) {
+	base := os.Getenv("XDG_STATE_HOME")
+	if base == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		base = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(base, "ccdbind", "history.jsonl"), nil
+}
+
+// Append writes s to the log at path, rotating it first if it has reached
+// maxBytes.
+func Append(path string, s Session, maxBytes int64) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(path); err == nil && maxBytes > 0 && fi.Size() >= maxBytes {
+		if err := os.Rename(path, path+".1"); err != nil {
+			return err
+		}
+	}
+	line, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Load reads the rotated and current logs, oldest first. Lines that do not
+// parse (e.g. a write cut short by a crash) are skipped.
+func Load(path string) ([]Session, error) {
+	var out []Session
+	for _, p := range []string{path + ".1", path} {
+		sessions, err := readFile(p)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		out = append(out, sessions...)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Start.Before(out[j].Start) })
+	return out, nil
+}
+
+func readFile(path string) ([]Session, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var out []Session
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	for sc.Scan() {
+		var s Session
+		if err := json.Unmarshal(sc.Bytes(), &s); err != nil {
+			continue
+		}
+		out = append(out, s)
+	}
+	return out, sc.Err()
+}
+
+// Filter keeps sessions that ended at or after since (zero: no limit) and,
+// if gameID is set, belong to that game.
+func Filter(sessions []Session, since time.Time, gameID string) []Session {
+	var out []Session
+	for _, s := range sessions {
+		if !since.IsZero() && s.End.Before(since) {
+			continue
+		}
+		if gameID != "" && s.GameID != gameID {
+			continue
+		}
+		out = append(out, s)
+	}
+	return out
+}
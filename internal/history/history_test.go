@@ -0,0 +1,71 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAppendLoadAndRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	start := time.Date(2026, 1, 2, 20, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		s := Session{GameID: "570", Start: start.Add(time.Duration(i) * time.Hour), End: start.Add(time.Duration(i)*time.Hour + 30*time.Minute), Placed: true}
+		// A tiny limit rotates before every write after the first.
+		if err := Append(path, s, 1); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Fatalf("expected rotated log: %v", err)
+	}
+
+	sessions, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	// Only one backup is kept, so the first session is gone.
+	if len(sessions) != 2 {
+		t.Fatalf("expected 2 sessions, got %d", len(sessions))
+	}
+	if !sessions[0].Start.Before(sessions[1].Start) {
+		t.Fatalf("expected oldest first: %#v", sessions)
+	}
+}
+
+func TestLoadSkipsBadLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	data := `{"game_id":"1","start":"2026-01-01T00:00:00Z","end":"2026-01-01T01:00:00Z"}
+{"game_id":"2","sta
+`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	sessions, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].GameID != "1" || sessions[0].Duration() != time.Hour {
+		t.Fatalf("unexpected sessions: %#v", sessions)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	now := time.Now()
+	sessions := []Session{
+		{GameID: "570", End: now.Add(-48 * time.Hour)},
+		{GameID: "570", End: now.Add(-time.Hour)},
+		{GameID: "730", End: now.Add(-time.Hour)},
+	}
+	if got := Filter(sessions, now.Add(-24*time.Hour), ""); len(got) != 2 {
+		t.Fatalf("since filter: %#v", got)
+	}
+	if got := Filter(sessions, time.Time{}, "570"); len(got) != 2 {
+		t.Fatalf("game filter: %#v", got)
+	}
+	if got := Filter(sessions, now.Add(-24*time.Hour), "570"); len(got) != 1 {
+		t.Fatalf("combined filter: %#v", got)
+	}
+}
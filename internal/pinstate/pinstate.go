@@ -0,0 +1,417 @@
+// Package pinstate is the shared record of OS-slice pins taken by ccdpin
+// instances when no daemon is running. Instances refcount one pin under a
+// file lock; the first snapshots the slices' originals and the last restores
+// them. The daemon reads the same file to leave slices alone while ccdpin
+// owns them, so only one component ever holds the originals.
+//
+// Writes are fsynced and mirrored to a backup, which Lock loads when the
+// state file turns out to be corrupt.
+package pinstate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/procscan"
+)
+
+type State struct {
+	Version             int               `json:"version"`
+	Instances           map[string]uint64 `json:"instances"`
+	OriginalAllowedCPUs map[string]string `json:"original_allowed_cpus"`
+	OSCPUs              string            `json:"os_cpus"`
+	Slices              []string          `json:"slices"`
+	UpdatedAt           time.Time         `json:"updated_at"`
+}
+
+// DefaultLockTimeout bounds how long Lock waits for another ccdpin.
+const DefaultLockTimeout = 10 * time.Second
+
+// ErrLockTimeout is returned when the lock is not acquired in time.
+var ErrLockTimeout = errors.New("timed out waiting for the ccdpin state lock")
+
+// ErrCorrupt is returned by Read when the state file cannot be parsed.
+var ErrCorrupt = errors.New("corrupt ccdpin state")
+
+// Store locates the state file and its lock inside a ccdpin state directory.
+type Store struct {
+	Path     string
+	LockPath string
+
+	// Timeout bounds the wait in Lock; zero means DefaultLockTimeout.
+	Timeout time.Duration
+	// Logf, if set, reports recoveries such as a quarantined state file.
+	Logf func(format string, args ...any)
+}
+
+func DefaultDir() (string, error) {
+	base := os.Getenv("XDG_STATE_HOME")
+	if base == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		base = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(base, "ccdpin"), nil
+}
+
+func New(dir string) Store {
+	return Store{Path: filepath.Join(dir, "state.json"), LockPath: filepath.Join(dir, "lock")}
+}
+
+// Default returns the Store in DefaultDir.
+func Default() (Store, error) {
+	dir, err := DefaultDir()
+	if err != nil {
+		return Store{}, err
+	}
+	return New(dir), nil
+}
+
+// BackupPath is the copy of the last good state that Lock falls back to
+// when the state file is corrupt.
+func (s Store) BackupPath() string {
+	return s.Path + ".bak"
+}
+
+func (s Store) logf(format string, args ...any) {
+	if s.Logf != nil {
+		s.Logf(format, args...)
+	}
+}
+
+// Lock takes the exclusive lock and loads the state. The caller must call
+// unlock, after Save if it changed the state. It gives up with
+// ErrLockTimeout after s.Timeout. A corrupt state file is moved aside and
+// the state is loaded from the backup instead, or starts empty.
+func (s Store) Lock() (unlock func(), st State, err error) {
+	if err := os.MkdirAll(filepath.Dir(s.LockPath), 0o755); err != nil {
+		return nil, State{}, err
+	}
+	f, err := os.OpenFile(s.LockPath, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, State{}, err
+	}
+	if err := flockTimeout(f, s.timeout()); err != nil {
+		_ = f.Close()
+		if errors.Is(err, ErrLockTimeout) {
+			return nil, State{}, fmt.Errorf("%w after %v: %s is held by another process (find it with `fuser %s`)", ErrLockTimeout, s.timeout(), s.LockPath, s.LockPath)
+		}
+		return nil, State{}, err
+	}
+	unlock = func() {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		_ = f.Close()
+	}
+	st, err = s.Read()
+	if errors.Is(err, ErrCorrupt) {
+		// Persist the recovered state now: the corrupt file is gone, and a
+		// caller that changes nothing would otherwise leave every later
+		// Read with an empty state.
+		if st, err = s.recoverCorrupt(err); err == nil {
+			err = s.Save(st)
+		}
+	}
+	if err != nil {
+		unlock()
+		return nil, State{}, err
+	}
+	return unlock, st, nil
+}
+
+func (s Store) timeout() time.Duration {
+	if s.Timeout > 0 {
+		return s.Timeout
+	}
+	return DefaultLockTimeout
+}
+
+// flockTimeout polls a non-blocking flock so a hung holder cannot block
+// ccdpin forever.
+func flockTimeout(f *os.File, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) && !errors.Is(err, syscall.EINTR) {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return ErrLockTimeout
+		}
+		time.Sleep(25 * time.Millisecond)
+	}
+}
+
+// recoverCorrupt renames the corrupt state file aside with a timestamp and
+// loads the backup. The caller must hold the lock.
+func (s Store) recoverCorrupt(cause error) (State, error) {
+	aside := fmt.Sprintf("%s.corrupt-%s", s.Path, time.Now().Format("20060102T150405"))
+	if err := os.Rename(s.Path, aside); err != nil {
+		return State{}, fmt.Errorf("quarantine %s: %w", s.Path, err)
+	}
+	st, err := readFile(s.BackupPath())
+	if err != nil {
+		s.logf("%v; moved it to %s and found no usable backup (%v), starting empty", cause, aside, err)
+		return State{Version: 1}, nil
+	}
+	s.logf("%v; moved it to %s and restored the state from %s", cause, aside, s.BackupPath())
+	return st, nil
+}
+
+// Read loads the state without locking, for observers such as the daemon.
+// A missing file is an empty state; an unparsable one is ErrCorrupt.
+func (s Store) Read() (State, error) {
+	return readFile(s.Path)
+}
+
+func readFile(path string) (State, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return State{Version: 1}, nil
+		}
+		return State{}, err
+	}
+	var st State
+	if err := json.Unmarshal(data, &st); err != nil {
+		return State{}, fmt.Errorf("%w: %s: %v", ErrCorrupt, path, err)
+	}
+	if st.Version == 0 {
+		st.Version = 1
+	}
+	return st, nil
+}
+
+// Save durably writes st and then its backup; the caller must hold the lock.
+func (s Store) Save(st State) error {
+	if st.Version == 0 {
+		st.Version = 1
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileSync(s.Path, data); err != nil {
+		return err
+	}
+	return writeFileSync(s.BackupPath(), data)
+}
+
+// writeFileSync replaces path atomically, syncing the data before the
+// rename and the directory after it, so a crash leaves the old or the new
+// file but never a torn one.
+func writeFileSync(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// alive reports whether pid is still the process that registered with
+// startTS. Tests replace it to simulate instances.
+var alive = func(pid int, startTS uint64) bool {
+	liveStart, err := procscan.StartTime(pid)
+	if err != nil {
+		return false
+	}
+	return startTS == 0 || liveStart == 0 || liveStart == startTS
+}
+
+// PruneDead drops instances whose process has exited or whose PID has been
+// reused.
+func (st *State) PruneDead() {
+	if len(st.Instances) == 0 {
+		return
+	}
+	out := map[string]uint64{}
+	for k, startTS := range st.Instances {
+		pid, err := strconv.Atoi(k)
+		if err != nil || pid <= 0 {
+			continue
+		}
+		if !alive(pid, startTS) {
+			continue
+		}
+		out[k] = startTS
+	}
+	st.Instances = out
+}
+
+// Pinned reports whether live instances hold the slices pinned. Call
+// PruneDead first.
+func (st State) Pinned() bool {
+	return len(st.Instances) > 0 && len(st.OriginalAllowedCPUs) > 0
+}
+
+// Acquire registers the instance pid (started at startTS). If no other
+// instance is alive it calls pin, which must pin the slices and record
+// their originals in st. Originals left by dead instances are restored with
+// restore first, so pin snapshots the real values and not the stale pin.
+func (s Store) Acquire(pid int, startTS uint64, pin func(st *State) error, restore func(unit, cpus string) error) error {
+	unlock, st, err := s.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	st.PruneDead()
+	key := strconv.Itoa(pid)
+	delete(st.Instances, key)
+	if len(st.Instances) == 0 {
+		if len(st.OriginalAllowedCPUs) > 0 {
+			if _, err := restoreLocked(&st, restore); err != nil {
+				_ = s.Save(st)
+				return fmt.Errorf("restore slices left by a dead ccdpin: %w", err)
+			}
+		}
+		if err := pin(&st); err != nil {
+			_ = s.Save(st)
+			return err
+		}
+	}
+	if st.Instances == nil {
+		st.Instances = map[string]uint64{}
+	}
+	st.Instances[key] = startTS
+	st.UpdatedAt = time.Now()
+	return s.Save(st)
+}
+
+// Release unregisters the instance and, if no live instance is left,
+// restores the slices and forgets the originals.
+func (s Store) Release(pid int, startTS uint64, restore func(unit, cpus string) error) error {
+	unlock, st, err := s.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	st.PruneDead()
+	key := strconv.Itoa(pid)
+	if ts, ok := st.Instances[key]; ok && (ts == 0 || startTS == 0 || ts == startTS) {
+		delete(st.Instances, key)
+	}
+	var errs []error
+	if len(st.Instances) == 0 && len(st.OriginalAllowedCPUs) > 0 {
+		_, err := restoreLocked(&st, restore)
+		errs = append(errs, err)
+	}
+	st.UpdatedAt = time.Now()
+	errs = append(errs, s.Save(st))
+	return errors.Join(errs...)
+}
+
+// Recovery reports what Recover did.
+type Recovery struct {
+	// Pruned counts the dead instances dropped.
+	Pruned int
+	// Live are the instances found alive, including those a forced
+	// Recover then dropped.
+	Live map[string]uint64
+	// Restored lists the restored slices; Originals holds the AllowedCPUs
+	// each was restored to.
+	Restored  []string
+	Originals map[string]string
+	// Pinned reports that live instances still hold the slices.
+	Pinned bool
+}
+
+// Recover drops dead instances and, once no live instance is left, restores
+// the slices from the saved originals with restore and forgets them. This is
+// what the last ccdpin would have done had it not been killed. With force the
+// slices are restored and the refcount cleared even while instances are
+// alive. Slices whose restore failed stay recorded so a later Recover can
+// retry them.
+func (s Store) Recover(force bool, restore func(unit, cpus string) error) (Recovery, error) {
+	unlock, st, err := s.Lock()
+	if err != nil {
+		return Recovery{}, err
+	}
+	defer unlock()
+
+	var rec Recovery
+	before := len(st.Instances)
+	st.PruneDead()
+	rec.Pruned = before - len(st.Instances)
+	rec.Live = st.Instances
+	changed := rec.Pruned > 0
+	if force {
+		changed = changed || len(st.Instances) > 0
+		st.Instances = nil
+	}
+
+	var errs []error
+	if len(st.Instances) == 0 && len(st.OriginalAllowedCPUs) > 0 {
+		rec.Originals = make(map[string]string, len(st.OriginalAllowedCPUs))
+		for unit, cpus := range st.OriginalAllowedCPUs {
+			rec.Originals[unit] = cpus
+		}
+		rec.Restored, err = restoreLocked(&st, restore)
+		errs = append(errs, err)
+		changed = true
+	}
+	rec.Pinned = st.Pinned()
+
+	if changed {
+		st.UpdatedAt = time.Now()
+		errs = append(errs, s.Save(st))
+	}
+	return rec, errors.Join(errs...)
+}
+
+// restoreLocked restores every recorded slice to its original. Restored
+// slices are forgotten; failed ones stay recorded for a retry.
+func restoreLocked(st *State, restore func(unit, cpus string) error) ([]string, error) {
+	var restored, failed []string
+	var errs []error
+	for _, unit := range st.Slices {
+		orig, ok := st.OriginalAllowedCPUs[unit]
+		if !ok {
+			continue
+		}
+		if err := restore(unit, orig); err != nil {
+			errs = append(errs, fmt.Errorf("restore %s: %w", unit, err))
+			failed = append(failed, unit)
+			continue
+		}
+		delete(st.OriginalAllowedCPUs, unit)
+		restored = append(restored, unit)
+	}
+	st.Slices = failed
+	if len(failed) == 0 {
+		st.OriginalAllowedCPUs = nil
+		st.OSCPUs = ""
+	}
+	return restored, errors.Join(errs...)
+}
@@ -0,0 +1,380 @@
+package pinstate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Reidond/ccdbind/internal/procscan"
+)
+
+func TestStoreLockSaveRead(t *testing.T) {
+	s := New(t.TempDir())
+
+	unlock, st, err := s.Lock()
+	if err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	if st.Version != 1 || len(st.Instances) != 0 {
+		t.Fatalf("unexpected empty state: %#v", st)
+	}
+	st.Instances = map[string]uint64{"42": 7}
+	st.OriginalAllowedCPUs = map[string]string{"app.slice": ""}
+	if err := s.Save(st); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	unlock()
+
+	got, err := s.Read()
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if got.Instances["42"] != 7 || !got.Pinned() {
+		t.Fatalf("unexpected state: %#v", got)
+	}
+}
+
+func TestPruneDead(t *testing.T) {
+	self := os.Getpid()
+	start, err := procscan.StartTime(self)
+	if err != nil {
+		t.Skipf("no start time: %v", err)
+	}
+	st := State{
+		Instances: map[string]uint64{
+			strconv.Itoa(self): start,
+			"999999999":        1,
+			"bogus":            1,
+		},
+		OriginalAllowedCPUs: map[string]string{"app.slice": "0-15"},
+	}
+	st.PruneDead()
+	if len(st.Instances) != 1 || st.Instances[strconv.Itoa(self)] != start {
+		t.Fatalf("unexpected instances: %#v", st.Instances)
+	}
+
+	st.Instances = map[string]uint64{strconv.Itoa(self): start + 1}
+	st.PruneDead()
+	if st.Pinned() {
+		t.Fatalf("reused pid kept: %#v", st.Instances)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	self := os.Getpid()
+	start, err := procscan.StartTime(self)
+	if err != nil {
+		t.Skipf("no start time: %v", err)
+	}
+	s := New(t.TempDir())
+	seed := State{
+		Instances:           map[string]uint64{strconv.Itoa(self): start, "999999999": 1},
+		OriginalAllowedCPUs: map[string]string{"app.slice": "", "background.slice": "0-3"},
+		OSCPUs:              "0-7",
+		Slices:              []string{"app.slice", "background.slice"},
+	}
+	if err := s.Save(seed); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	got := map[string]string{}
+	failBackground := true
+	restore := func(unit, cpus string) error {
+		got[unit] = cpus
+		if unit == "background.slice" && failBackground {
+			return errors.New("boom")
+		}
+		return nil
+	}
+
+	// A live instance keeps the pin; only the dead one is pruned.
+	rec, err := s.Recover(false, restore)
+	if err != nil || len(rec.Restored) != 0 || len(got) != 0 || rec.Pruned != 1 || !rec.Pinned {
+		t.Fatalf("Recover with live instance: %+v err=%v calls=%v", rec, err, got)
+	}
+	st, _ := s.Read()
+	if len(st.Instances) != 1 || !st.Pinned() {
+		t.Fatalf("unexpected state after prune: %#v", st)
+	}
+
+	// Forced, a failing slice stays recorded for a retry.
+	rec, err = s.Recover(true, restore)
+	if err == nil || len(rec.Restored) != 1 || rec.Restored[0] != "app.slice" || len(rec.Live) != 1 || rec.Pinned {
+		t.Fatalf("forced Recover: %+v err=%v", rec, err)
+	}
+	if cpus, ok := rec.Originals["app.slice"]; !ok || cpus != "" {
+		t.Fatalf("forced Recover originals: %v", rec.Originals)
+	}
+	st, _ = s.Read()
+	if len(st.Instances) != 0 || len(st.Slices) != 1 || st.OriginalAllowedCPUs["background.slice"] != "0-3" {
+		t.Fatalf("unexpected state after partial restore: %#v", st)
+	}
+
+	failBackground = false
+	rec, err = s.Recover(false, restore)
+	if err != nil || len(rec.Restored) != 1 || rec.Restored[0] != "background.slice" || rec.Originals["background.slice"] != "0-3" {
+		t.Fatalf("retry Recover: %+v err=%v", rec, err)
+	}
+	st, _ = s.Read()
+	if len(st.OriginalAllowedCPUs) != 0 || len(st.Slices) != 0 || st.OSCPUs != "" {
+		t.Fatalf("state not cleared: %#v", st)
+	}
+}
+
+func TestLockTimeout(t *testing.T) {
+	dir := t.TempDir()
+	holder := New(dir)
+	unlock, _, err := holder.Lock()
+	if err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	defer unlock()
+
+	waiter := New(dir)
+	waiter.Timeout = 100 * time.Millisecond
+	start := time.Now()
+	if _, _, err := waiter.Lock(); !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("expected ErrLockTimeout, got %v", err)
+	}
+	if waited := time.Since(start); waited < 100*time.Millisecond || waited > 2*time.Second {
+		t.Fatalf("unexpected wait %v", waited)
+	}
+}
+
+func TestLockQuarantinesCorruptState(t *testing.T) {
+	dir := t.TempDir()
+	s := New(dir)
+	var logged []string
+	s.Logf = func(format string, args ...any) { logged = append(logged, fmt.Sprintf(format, args...)) }
+
+	good := State{OriginalAllowedCPUs: map[string]string{"app.slice": "0-15"}, OSCPUs: "0-7", Slices: []string{"app.slice"}}
+	if err := s.Save(good); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if err := os.WriteFile(s.Path, []byte("{not json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Read(); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected ErrCorrupt from Read, got %v", err)
+	}
+
+	unlock, st, err := s.Lock()
+	if err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	unlock()
+	if st.OriginalAllowedCPUs["app.slice"] != "0-15" || st.OSCPUs != "0-7" {
+		t.Fatalf("originals not recovered from backup: %#v", st)
+	}
+	aside, _ := filepath.Glob(s.Path + ".corrupt-*")
+	if len(aside) != 1 {
+		t.Fatalf("expected one quarantined file, got %v", aside)
+	}
+	if st, err := s.Read(); err != nil || st.OriginalAllowedCPUs["app.slice"] != "0-15" {
+		t.Fatalf("recovered state not written back: %#v %v", st, err)
+	}
+	if len(logged) != 1 {
+		t.Fatalf("expected one log line, got %q", logged)
+	}
+
+	// Without a usable backup the state starts empty.
+	if err := os.WriteFile(s.Path, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(s.BackupPath(), []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	unlock, st, err = s.Lock()
+	if err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	unlock()
+	if len(st.OriginalAllowedCPUs) != 0 || st.Version != 1 {
+		t.Fatalf("expected empty state, got %#v", st)
+	}
+}
+
+func TestRecoverPersistsBackupOfCorruptState(t *testing.T) {
+	fakeLiveness(t, func(pid int) bool { return pid == 100 })
+	s := New(t.TempDir())
+	good := State{
+		Instances:           map[string]uint64{"100": 1},
+		OriginalAllowedCPUs: map[string]string{"app.slice": "0-15"},
+		OSCPUs:              "0-7",
+		Slices:              []string{"app.slice"},
+	}
+	if err := s.Save(good); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if err := os.WriteFile(s.Path, []byte("{not json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The live instance keeps the pin, so Recover itself changes nothing.
+	rec, err := s.Recover(false, func(unit, cpus string) error {
+		t.Errorf("restore %s while an instance is alive", unit)
+		return nil
+	})
+	if err != nil || !rec.Pinned || rec.Pruned != 0 {
+		t.Fatalf("Recover: %+v err=%v", rec, err)
+	}
+	for i := 0; i < 2; i++ {
+		st, err := s.Read()
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		if !st.Pinned() || st.OriginalAllowedCPUs["app.slice"] != "0-15" || st.OSCPUs != "0-7" {
+			t.Fatalf("backup not persisted: %#v", st)
+		}
+		// A later Lock sees the same state rather than an empty one.
+		unlock, _, err := s.Lock()
+		if err != nil {
+			t.Fatalf("Lock: %v", err)
+		}
+		unlock()
+	}
+}
+
+// fakeSlice stands in for a slice's AllowedCPUs and fails the test if it
+// is pinned twice or restored while not pinned.
+type fakeSlice struct {
+	t      *testing.T
+	mu     sync.Mutex
+	cpus   string
+	pins   int
+	orig   string
+	pinned string
+}
+
+func (f *fakeSlice) pin(st *State) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.cpus != f.orig {
+		f.t.Errorf("pin while already pinned (cpus=%q)", f.cpus)
+	}
+	st.OriginalAllowedCPUs = map[string]string{"app.slice": f.cpus}
+	st.OSCPUs = f.pinned
+	st.Slices = []string{"app.slice"}
+	f.cpus = f.pinned
+	f.pins++
+	return nil
+}
+
+func (f *fakeSlice) restore(unit, cpus string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.cpus != f.pinned {
+		f.t.Errorf("restore %s while not pinned (cpus=%q)", unit, f.cpus)
+	}
+	f.cpus = cpus
+	return nil
+}
+
+// fakeLiveness replaces the process liveness check for the test.
+func fakeLiveness(t *testing.T, live func(pid int) bool) {
+	prev := alive
+	alive = func(pid int, _ uint64) bool { return live(pid) }
+	t.Cleanup(func() { alive = prev })
+}
+
+func TestAcquireRestoresOriginalsOfDeadInstance(t *testing.T) {
+	fakeLiveness(t, func(pid int) bool { return pid != 100 })
+	s := New(t.TempDir())
+	// Instance 100 pinned the slice and was killed without restoring it.
+	slice := &fakeSlice{t: t, cpus: "0-3", orig: "", pinned: "0-3"}
+	if err := s.Save(State{
+		Instances:           map[string]uint64{"100": 1},
+		OriginalAllowedCPUs: map[string]string{"app.slice": ""},
+		OSCPUs:              "0-3",
+		Slices:              []string{"app.slice"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Acquire(200, 1, slice.pin, slice.restore); err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	st, _ := s.Read()
+	if st.OriginalAllowedCPUs["app.slice"] != "" || len(st.Instances) != 1 {
+		t.Fatalf("stale pin snapshotted as original: %#v", st)
+	}
+	if err := s.Release(200, 1, slice.restore); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if slice.cpus != "" {
+		t.Fatalf("slice not restored: %q", slice.cpus)
+	}
+}
+
+func TestConcurrentAcquireRelease(t *testing.T) {
+	var mu sync.Mutex
+	live := map[int]bool{}
+	fakeLiveness(t, func(pid int) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return live[pid]
+	})
+
+	dir := t.TempDir()
+	slice := &fakeSlice{t: t, cpus: "", orig: "", pinned: "0-7"}
+	const instances, rounds = 8, 20
+
+	var wg sync.WaitGroup
+	for i := 0; i < instances; i++ {
+		wg.Add(1)
+		go func(pid int) {
+			defer wg.Done()
+			// Each instance opens its own lock, like separate processes.
+			s := New(dir)
+			for r := 0; r < rounds; r++ {
+				mu.Lock()
+				live[pid] = true
+				mu.Unlock()
+				if err := s.Acquire(pid, uint64(r+1), slice.pin, slice.restore); err != nil {
+					t.Errorf("Acquire(%d): %v", pid, err)
+					return
+				}
+				time.Sleep(time.Duration(pid%3) * time.Millisecond)
+				if pid == 1000 && r == rounds-1 {
+					// Killed while holding the pin: it never releases.
+					mu.Lock()
+					delete(live, pid)
+					mu.Unlock()
+					return
+				}
+				if err := s.Release(pid, uint64(r+1), slice.restore); err != nil {
+					t.Errorf("Release(%d): %v", pid, err)
+					return
+				}
+				mu.Lock()
+				delete(live, pid)
+				mu.Unlock()
+			}
+		}(1000 + i)
+	}
+	wg.Wait()
+
+	// Whoever released last may have run before the killed instance died;
+	// recovery restores the slice either way.
+	if _, err := New(dir).Recover(false, slice.restore); err != nil {
+		t.Fatalf("Recover: %v", err)
+	}
+	if slice.cpus != "" {
+		t.Fatalf("slice left pinned: %q", slice.cpus)
+	}
+	if slice.pins == 0 {
+		t.Fatalf("slice never pinned")
+	}
+	st, err := New(dir).Read()
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if len(st.Instances) != 0 || len(st.OriginalAllowedCPUs) != 0 {
+		t.Fatalf("state not cleared: %#v", st)
+	}
+}
@@ -3,11 +3,14 @@ package procscan
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
+	"unsafe"
 
 	"github.com/Reidond/ccdbind/internal/topology"
 )
@@ -23,6 +26,93 @@ func AllowedCPUs(pid int) (string, error) {
 	return allowedCPUsAt("/proc", pid)
 }
 
+// ThreadIDs lists the thread IDs of pid from /proc/<pid>/task.
+func ThreadIDs(pid int) ([]int, error) {
+	return threadIDsAt("/proc", pid)
+}
+
+func threadIDsAt(procRoot string, pid int) ([]int, error) {
+	ents, err := os.ReadDir(filepath.Join(procRoot, strconv.Itoa(pid), "task"))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]int, 0, len(ents))
+	for _, ent := range ents {
+		tid, err := strconv.Atoi(ent.Name())
+		if err != nil || tid <= 0 {
+			continue
+		}
+		out = append(out, tid)
+	}
+	return out, nil
+}
+
+// SetAffinity applies sched_setaffinity(2) to a single thread. Threads created
+// afterwards by that thread inherit the mask.
+func SetAffinity(tid int, cpus []int) error {
+	mask, err := cpuMask(cpus)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, uintptr(tid), uintptr(len(mask)*8), uintptr(unsafe.Pointer(&mask[0])))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// SetProcessAffinity applies SetAffinity to every thread of pid. Passes
+// repeat until one finds no thread it has not pinned yet, so threads started
+// meanwhile by a still unpinned thread are covered too. Children forked
+// afterwards by any thread inherit the mask.
+func SetProcessAffinity(pid int, cpus []int) error {
+	done := map[int]bool{}
+	for {
+		tids, err := ThreadIDs(pid)
+		if err != nil {
+			return err
+		}
+		pinned := 0
+		for _, tid := range tids {
+			if done[tid] {
+				continue
+			}
+			if err := SetAffinity(tid, cpus); err != nil {
+				if errors.Is(err, syscall.ESRCH) {
+					// The thread exited.
+					continue
+				}
+				return fmt.Errorf("tid %d: %w", tid, err)
+			}
+			done[tid] = true
+			pinned++
+		}
+		if pinned == 0 {
+			return nil
+		}
+	}
+}
+
+func cpuMask(cpus []int) ([]uint64, error) {
+	highest := -1
+	for _, cpu := range cpus {
+		if cpu < 0 {
+			return nil, fmt.Errorf("invalid cpu %d", cpu)
+		}
+		if cpu > highest {
+			highest = cpu
+		}
+	}
+	if highest < 0 {
+		return nil, fmt.Errorf("empty cpu set")
+	}
+	mask := make([]uint64, highest/64+1)
+	for _, cpu := range cpus {
+		mask[cpu/64] |= 1 << (uint(cpu) % 64)
+	}
+	return mask, nil
+}
+
 func ScanUserCPUConstraints(uid int) ([]CPUConstraint, error) {
 	return scanUserCPUConstraintsAt("/proc", uid)
 }
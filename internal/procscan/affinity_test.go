@@ -1,6 +1,13 @@
 package procscan
 
-import "testing"
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/Reidond/ccdbind/internal/topology"
+)
 
 func TestAllowedCPUsFromStatus(t *testing.T) {
 	status := "" +
@@ -24,3 +31,55 @@ func TestAllowedCPUsFromStatusMissing(t *testing.T) {
 		t.Fatalf("expected missing")
 	}
 }
+
+func TestCPUMask(t *testing.T) {
+	mask, err := cpuMask([]int{0, 3, 64, 65})
+	if err != nil {
+		t.Fatalf("cpuMask: %v", err)
+	}
+	if len(mask) != 2 || mask[0] != 0b1001 || mask[1] != 0b11 {
+		t.Fatalf("unexpected mask: %b", mask)
+	}
+	if _, err := cpuMask(nil); err == nil {
+		t.Fatalf("expected empty set to be rejected")
+	}
+}
+
+func TestSetProcessAffinity(t *testing.T) {
+	self := os.Getpid()
+	orig, err := AllowedCPUs(self)
+	if err != nil {
+		t.Skipf("no Cpus_allowed_list: %v", err)
+	}
+	all, err := topology.ParseCPUList(orig)
+	if err != nil || len(all) == 0 {
+		t.Skipf("unexpected allowed list %q", orig)
+	}
+	t.Cleanup(func() {
+		if err := SetProcessAffinity(self, all); err != nil {
+			t.Errorf("restore affinity: %v", err)
+		}
+	})
+
+	if err := SetProcessAffinity(self, all[:1]); err != nil {
+		t.Fatalf("SetProcessAffinity: %v", err)
+	}
+	tids, err := ThreadIDs(self)
+	if err != nil {
+		t.Fatalf("ThreadIDs: %v", err)
+	}
+	want := strconv.Itoa(all[0])
+	for _, tid := range tids {
+		data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(self), "task", strconv.Itoa(tid), "status"))
+		if err != nil {
+			continue
+		}
+		if got, _ := allowedCPUsFromStatus(data); got != want {
+			t.Fatalf("tid %d allowed %q, want %q", tid, got, want)
+		}
+	}
+
+	if err := SetProcessAffinity(self, nil); err == nil {
+		t.Fatalf("expected error for an empty set")
+	}
+}
@@ -0,0 +1,59 @@
+package procscan
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// CgroupPath returns the cgroup v2 path of pid relative to the cgroup root,
+// e.g. "/user.slice/user-1000.slice/user@1000.service/game.slice/game-1.scope".
+func CgroupPath(pid int) (string, error) {
+	return cgroupPathAt("/proc", pid)
+}
+
+func cgroupPathAt(procRoot string, pid int) (string, error) {
+	data, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return "", err
+	}
+	return cgroupFromProc(data), nil
+}
+
+// cgroupFromProc extracts the unified hierarchy path from /proc/<pid>/cgroup.
+// On hybrid setups without a "0::" entry it falls back to the name=systemd
+// hierarchy, which mirrors the unit layout.
+func cgroupFromProc(data []byte) string {
+	fallback := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] == "0" && parts[1] == "" {
+			return strings.TrimSpace(parts[2])
+		}
+		if parts[1] == "name=systemd" {
+			fallback = strings.TrimSpace(parts[2])
+		}
+	}
+	return fallback
+}
+
+// CgroupInUnit reports whether cgroupPath lies inside the given systemd unit,
+// i.e. whether one of its path components is the unit name.
+func CgroupInUnit(cgroupPath string, unit string) bool {
+	if cgroupPath == "" || unit == "" {
+		return false
+	}
+	for _, part := range strings.Split(cgroupPath, "/") {
+		if part == unit {
+			return true
+		}
+	}
+	return false
+}
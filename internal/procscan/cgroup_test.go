@@ -0,0 +1,30 @@
+package procscan
+
+import "testing"
+
+func TestCgroupFromProc(t *testing.T) {
+	unified := "0::/user.slice/user-1000.slice/user@1000.service/game.slice/game-42.scope\n"
+	if got := cgroupFromProc([]byte(unified)); got != "/user.slice/user-1000.slice/user@1000.service/game.slice/game-42.scope" {
+		t.Fatalf("unexpected unified path: %q", got)
+	}
+
+	hybrid := "" +
+		"12:cpuset:/\n" +
+		"1:name=systemd:/user.slice/user-1000.slice/user@1000.service/app.slice/foo.scope\n"
+	if got := cgroupFromProc([]byte(hybrid)); got != "/user.slice/user-1000.slice/user@1000.service/app.slice/foo.scope" {
+		t.Fatalf("unexpected hybrid path: %q", got)
+	}
+}
+
+func TestCgroupInUnit(t *testing.T) {
+	path := "/user.slice/user-1000.slice/user@1000.service/game.slice/game-42.scope"
+	if !CgroupInUnit(path, "game-42.scope") {
+		t.Fatalf("expected path to be in game-42.scope")
+	}
+	if CgroupInUnit(path, "game-4.scope") {
+		t.Fatalf("expected prefix match to be rejected")
+	}
+	if CgroupInUnit("", "game-42.scope") {
+		t.Fatalf("expected empty path to be rejected")
+	}
+}
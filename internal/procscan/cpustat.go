@@ -0,0 +1,56 @@
+package procscan
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// CPUTime is a CPU's cumulative time from /proc/stat, in clock ticks.
+// Busy excludes idle and iowait.
+type CPUTime struct {
+	Busy  uint64
+	Total uint64
+}
+
+// CPUTimes reads per-CPU times from /proc/stat, keyed by CPU number.
+func CPUTimes() (map[int]CPUTime, error) {
+	data, err := os.ReadFile(filepath.Join("/proc", "stat"))
+	if err != nil {
+		return nil, err
+	}
+	return parseCPUTimes(string(data)), nil
+}
+
+func parseCPUTimes(data string) map[int]CPUTime {
+	out := map[int]CPUTime{}
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 || !strings.HasPrefix(fields[0], "cpu") || fields[0] == "cpu" {
+			continue
+		}
+		cpu, err := strconv.Atoi(strings.TrimPrefix(fields[0], "cpu"))
+		if err != nil {
+			continue
+		}
+		var t CPUTime
+		// user nice system idle iowait irq softirq steal; guest time is
+		// already counted in user.
+		for i, f := range fields[1:] {
+			if i >= 8 {
+				break
+			}
+			v, err := strconv.ParseUint(f, 10, 64)
+			if err != nil {
+				continue
+			}
+			t.Total += v
+			if i != 3 && i != 4 {
+				t.Busy += v
+			}
+		}
+		out[cpu] = t
+	}
+	return out
+}
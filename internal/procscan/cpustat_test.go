@@ -0,0 +1,20 @@
+package procscan
+
+import "testing"
+
+func TestParseCPUTimes(t *testing.T) {
+	data := "cpu  10 0 10 100 5 0 0 0 0 0\n" +
+		"cpu0 4 1 3 50 2 1 1 0 7 0\n" +
+		"cpu1 6 0 7 50 3 0 0 0 0 0\n" +
+		"intr 12345\n"
+	got := parseCPUTimes(data)
+	if len(got) != 2 {
+		t.Fatalf("unexpected cpus: %#v", got)
+	}
+	if got[0].Busy != 10 || got[0].Total != 62 {
+		t.Fatalf("unexpected cpu0: %#v", got[0])
+	}
+	if got[1].Busy != 13 || got[1].Total != 66 {
+		t.Fatalf("unexpected cpu1: %#v", got[1])
+	}
+}
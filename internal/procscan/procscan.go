@@ -17,11 +17,15 @@ type GameProcess struct {
 	Exe       string
 	GameID    string
 	IDSource  string
+	Cgroup    string
 }
 
 type Scanner struct {
 	UID int
 
+	// Scanned is the number of /proc PID entries examined by the last Scan.
+	Scanned int
+
 	envKeyOrder []string
 	envKeyIndex map[string]int
 
@@ -59,6 +63,7 @@ func (s *Scanner) Scan() (map[string][]GameProcess, error) {
 		return nil, err
 	}
 	results := map[string][]GameProcess{}
+	s.Scanned = 0
 	for _, ent := range ents {
 		if !ent.IsDir() {
 			continue
@@ -67,6 +72,7 @@ func (s *Scanner) Scan() (map[string][]GameProcess, error) {
 		if err != nil || pid <= 0 {
 			continue
 		}
+		s.Scanned++
 		owned, err := isOwnedByUID(pid, s.UID)
 		if err != nil || !owned {
 			continue
@@ -95,12 +101,36 @@ func (s *Scanner) Scan() (map[string][]GameProcess, error) {
 		if err != nil {
 			startTime = 0
 		}
-		gp := GameProcess{PID: pid, StartTime: startTime, Exe: exeBase, GameID: id, IDSource: src}
+		cgroup, err := CgroupPath(pid)
+		if err != nil {
+			cgroup = ""
+		}
+		gp := GameProcess{PID: pid, StartTime: startTime, Exe: exeBase, GameID: id, IDSource: src, Cgroup: cgroup}
 		results[id] = append(results[id], gp)
 	}
 	return results, nil
 }
 
+// ProcessInfo describes pid the way Scan would, without requiring it to match
+// any detection rule. GameID and IDSource are left for the caller to fill.
+func ProcessInfo(pid int) (GameProcess, error) {
+	startTime, err := procStartTime(pid)
+	if err != nil {
+		return GameProcess{}, err
+	}
+	cgroup, err := CgroupPath(pid)
+	if err != nil {
+		cgroup = ""
+	}
+	return GameProcess{PID: pid, StartTime: startTime, Exe: exeBasenameLower(pid), Cgroup: cgroup}, nil
+}
+
+// StartTime returns the start time of pid in clock ticks since boot, which
+// together with the PID identifies a process instance.
+func StartTime(pid int) (uint64, error) {
+	return procStartTime(pid)
+}
+
 func procStartTime(pid int) (uint64, error) {
 	path := filepath.Join("/proc", strconv.Itoa(pid), "stat")
 	data, err := os.ReadFile(path)
@@ -202,6 +232,11 @@ func (s *Scanner) gameIDFromEnviron(pid int) (string, string) {
 	return bestVal, bestKey
 }
 
+// OwnedBy reports whether pid runs with the real UID uid.
+func OwnedBy(pid, uid int) (bool, error) {
+	return isOwnedByUID(pid, uid)
+}
+
 func isOwnedByUID(pid int, uid int) (bool, error) {
 	path := filepath.Join("/proc", strconv.Itoa(pid), "status")
 	f, err := os.Open(path)
@@ -0,0 +1,30 @@
+package procscan
+
+import (
+	"os"
+	"testing"
+)
+
+func TestProcessInfoSelf(t *testing.T) {
+	gp, err := ProcessInfo(os.Getpid())
+	if err != nil {
+		t.Fatalf("ProcessInfo: %v", err)
+	}
+	if gp.StartTime == 0 || gp.Exe == "" {
+		t.Fatalf("unexpected process info: %#v", gp)
+	}
+}
+
+func TestOwnedBy(t *testing.T) {
+	owned, err := OwnedBy(os.Getpid(), os.Getuid())
+	if err != nil || !owned {
+		t.Fatalf("OwnedBy(self) = %v, %v", owned, err)
+	}
+	owned, err = OwnedBy(os.Getpid(), os.Getuid()+1)
+	if err != nil || owned {
+		t.Fatalf("OwnedBy(self, other uid) = %v, %v", owned, err)
+	}
+	if _, err := OwnedBy(-1, os.Getuid()); err == nil {
+		t.Fatalf("expected an error for a missing process")
+	}
+}
@@ -0,0 +1,110 @@
+package procscan
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ThreadSched is a snapshot of one thread's scheduling counters.
+type ThreadSched struct {
+	TID  int
+	Comm string
+	// StartTime is the thread's start time in clock ticks since boot (stat
+	// field 22); with TID it identifies the thread across TID reuse.
+	StartTime uint64
+	// Processor is the CPU the thread last ran on (stat field 39).
+	Processor int
+	// CPUTicks is utime+stime in clock ticks.
+	CPUTicks uint64
+	// Migrations is se.nr_migrations from /proc/<pid>/task/<tid>/sched,
+	// valid only if HasMigrations (the file needs CONFIG_SCHED_DEBUG).
+	Migrations    uint64
+	HasMigrations bool
+}
+
+// ThreadSchedStats samples every thread of pid. Threads that exit while
+// being read are skipped.
+func ThreadSchedStats(pid int) ([]ThreadSched, error) {
+	return threadSchedStatsAt("/proc", pid)
+}
+
+func threadSchedStatsAt(procRoot string, pid int) ([]ThreadSched, error) {
+	tids, err := threadIDsAt(procRoot, pid)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]ThreadSched, 0, len(tids))
+	for _, tid := range tids {
+		dir := filepath.Join(procRoot, strconv.Itoa(pid), "task", strconv.Itoa(tid))
+		ts, err := threadStat(filepath.Join(dir, "stat"))
+		if err != nil {
+			continue
+		}
+		ts.TID = tid
+		if n, err := schedMigrations(filepath.Join(dir, "sched")); err == nil {
+			ts.Migrations, ts.HasMigrations = n, true
+		}
+		out = append(out, ts)
+	}
+	return out, nil
+}
+
+func threadStat(path string) (ThreadSched, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ThreadSched{}, err
+	}
+	line := strings.TrimSpace(string(data))
+	open := strings.IndexByte(line, '(')
+	idx := strings.LastIndexByte(line, ')')
+	if open == -1 || idx < open || idx+2 >= len(line) {
+		return ThreadSched{}, fmt.Errorf("invalid stat format")
+	}
+	comm := line[open+1 : idx]
+	// fields[0] is field 3 (state) of proc(5).
+	fields := strings.Fields(line[idx+2:])
+	if len(fields) <= 36 {
+		return ThreadSched{}, fmt.Errorf("stat too short")
+	}
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return ThreadSched{}, err
+	}
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return ThreadSched{}, err
+	}
+	start, err := strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return ThreadSched{}, err
+	}
+	cpu, err := strconv.Atoi(fields[36])
+	if err != nil {
+		return ThreadSched{}, err
+	}
+	return ThreadSched{Comm: comm, StartTime: start, Processor: cpu, CPUTicks: utime + stime}, nil
+}
+
+func schedMigrations(path string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		key, val, ok := strings.Cut(sc.Text(), ":")
+		if !ok || strings.TrimSpace(key) != "se.nr_migrations" {
+			continue
+		}
+		return strconv.ParseUint(strings.TrimSpace(val), 10, 64)
+	}
+	if err := sc.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no se.nr_migrations in %s", path)
+}
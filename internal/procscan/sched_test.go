@@ -0,0 +1,43 @@
+package procscan
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestThreadSchedStats(t *testing.T) {
+	root := t.TempDir()
+	task := filepath.Join(root, "42", "task")
+	for _, tid := range []string{"42", "43"} {
+		if err := os.MkdirAll(filepath.Join(task, tid), 0o755); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+	}
+	// Fields 14/15 (utime/stime) are 30/12, field 22 (starttime) is 12345,
+	// field 39 (processor) is 9.
+	stat := "42 (game (main)) R 1 42 42 0 -1 4194304 100 0 0 0 30 12 0 0 20 0 8 0 12345 1000 100 18446744073709551615 1 1 0 0 0 0 0 0 0 0 0 0 17 9 0 0 0 0 0\n"
+	if err := os.WriteFile(filepath.Join(task, "42", "stat"), []byte(stat), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	sched := "game (42, #threads: 2)\n-------------------\nse.exec_start : 1.0\nse.nr_migrations                             :                   17\n"
+	if err := os.WriteFile(filepath.Join(task, "42", "sched"), []byte(sched), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	got, err := threadSchedStatsAt(root, 42)
+	if err != nil {
+		t.Fatalf("threadSchedStatsAt: %v", err)
+	}
+	// Thread 43 has no stat file and is skipped.
+	if len(got) != 1 {
+		t.Fatalf("unexpected threads: %#v", got)
+	}
+	ts := got[0]
+	if ts.TID != 42 || ts.Comm != "game (main)" || ts.StartTime != 12345 || ts.Processor != 9 || ts.CPUTicks != 42 {
+		t.Fatalf("unexpected stat: %#v", ts)
+	}
+	if !ts.HasMigrations || ts.Migrations != 17 {
+		t.Fatalf("unexpected migrations: %#v", ts)
+	}
+}
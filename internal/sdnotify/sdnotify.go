@@ -0,0 +1,74 @@
+// Package sdnotify implements the sd_notify(3) protocol: newline-separated
+// KEY=VALUE assignments sent as one datagram to the socket in $NOTIFY_SOCKET.
+package sdnotify
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+	Watchdog = "WATCHDOG=1"
+)
+
+// Status formats a STATUS= line shown by `systemctl status`.
+func Status(s string) string {
+	return "STATUS=" + s
+}
+
+// Notify sends state to $NOTIFY_SOCKET. It reports false with a nil error
+// when the variable is unset, i.e. the service is not Type=notify.
+func Notify(state string) (bool, error) {
+	return notify(os.Getenv("NOTIFY_SOCKET"), state)
+}
+
+func notify(socket, state string) (bool, error) {
+	if socket == "" {
+		return false, nil
+	}
+	// A leading '@' names a socket in the abstract namespace.
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns how often WATCHDOG=1 should be sent: half of
+// $WATCHDOG_USEC, as sd_watchdog_enabled(3) recommends. It returns 0 when
+// the watchdog is off or $WATCHDOG_PID names another process.
+func WatchdogInterval() (time.Duration, error) {
+	return watchdogInterval(os.Getenv("WATCHDOG_USEC"), os.Getenv("WATCHDOG_PID"), os.Getpid())
+}
+
+func watchdogInterval(usec, pid string, self int) (time.Duration, error) {
+	if usec == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid WATCHDOG_USEC %q", usec)
+	}
+	if pid != "" {
+		p, err := strconv.Atoi(pid)
+		if err != nil {
+			return 0, fmt.Errorf("invalid WATCHDOG_PID %q", pid)
+		}
+		if p != self {
+			return 0, nil
+		}
+	}
+	return time.Duration(n) * time.Microsecond / 2, nil
+}
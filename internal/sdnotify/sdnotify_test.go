@@ -0,0 +1,58 @@
+package sdnotify
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNotifySendsDatagram(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	ln, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("ListenUnixgram: %v", err)
+	}
+	defer ln.Close()
+
+	sent, err := notify(path, Ready+"\n"+Status("idle"))
+	if err != nil || !sent {
+		t.Fatalf("notify: sent=%v err=%v", sent, err)
+	}
+	buf := make([]byte, 256)
+	_ = ln.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := ln.Read(buf)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if got := string(buf[:n]); got != "READY=1\nSTATUS=idle" {
+		t.Fatalf("unexpected datagram %q", got)
+	}
+}
+
+func TestNotifyWithoutSocket(t *testing.T) {
+	sent, err := notify("", Ready)
+	if sent || err != nil {
+		t.Fatalf("expected no-op, got sent=%v err=%v", sent, err)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	cases := []struct {
+		usec, pid string
+		want      time.Duration
+		wantErr   bool
+	}{
+		{"", "", 0, false},
+		{"30000000", "", 15 * time.Second, false},
+		{"30000000", "42", 15 * time.Second, false},
+		{"30000000", "43", 0, false},
+		{"abc", "", 0, true},
+	}
+	for _, tc := range cases {
+		got, err := watchdogInterval(tc.usec, tc.pid, 42)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Fatalf("watchdogInterval(%q, %q) = %v, %v; want %v (err=%v)", tc.usec, tc.pid, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
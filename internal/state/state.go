@@ -17,6 +17,109 @@ type File struct {
 	UpdatedAt              time.Time         `json:"updated_at"`
 	LastSuccessfulRestore  time.Time         `json:"last_successful_restore"`
 	LastSuccessfulPinApply time.Time         `json:"last_successful_pin_apply"`
+	LastScopeGC            *ScopeReport      `json:"last_scope_gc,omitempty"`
+	Overrides              Overrides         `json:"overrides"`
+}
+
+// Overrides are the manual controls set by `ccdbind pause|resume|pin|unpin`.
+// They live in the state file so they survive daemon restarts.
+type Overrides struct {
+	// Paused stops the daemon from touching slices and scopes, until
+	// PausedUntil if set, otherwise until resumed.
+	Paused      bool      `json:"paused"`
+	PausedUntil time.Time `json:"paused_until"`
+
+	// PinnedPIDs are treated as games until they exit; PinnedGames skip
+	// start_delay. Excluded PIDs and games are ignored by the daemon.
+	PinnedPIDs    []PIDRef `json:"pinned_pids,omitempty"`
+	PinnedGames   []string `json:"pinned_games,omitempty"`
+	ExcludedPIDs  []PIDRef `json:"excluded_pids,omitempty"`
+	ExcludedGames []string `json:"excluded_games,omitempty"`
+}
+
+// PIDRef identifies a process instance; StartTime guards against PID reuse.
+type PIDRef struct {
+	PID       int    `json:"pid"`
+	StartTime uint64 `json:"start_time"`
+}
+
+// IsPaused reports whether the pause is in effect at now.
+func (o Overrides) IsPaused(now time.Time) bool {
+	return o.Paused && (o.PausedUntil.IsZero() || now.Before(o.PausedUntil))
+}
+
+// Pause pauses until t, or indefinitely when t is zero.
+func (o *Overrides) Pause(t time.Time) {
+	o.Paused = true
+	o.PausedUntil = t
+}
+
+func (o *Overrides) Resume() {
+	o.Paused = false
+	o.PausedUntil = time.Time{}
+}
+
+// PinPID makes ref a game process and lifts any exclusion.
+func (o *Overrides) PinPID(ref PIDRef) {
+	o.ExcludedPIDs = removePID(o.ExcludedPIDs, ref.PID)
+	o.PinnedPIDs = append(removePID(o.PinnedPIDs, ref.PID), ref)
+}
+
+// UnpinPID undoes a manual pin of ref, or excludes it if it was not pinned.
+func (o *Overrides) UnpinPID(ref PIDRef) {
+	for _, p := range o.PinnedPIDs {
+		if p.PID == ref.PID {
+			o.PinnedPIDs = removePID(o.PinnedPIDs, ref.PID)
+			return
+		}
+	}
+	o.ExcludedPIDs = append(removePID(o.ExcludedPIDs, ref.PID), ref)
+}
+
+// PinGame marks id as pinned and lifts any exclusion.
+func (o *Overrides) PinGame(id string) {
+	o.ExcludedGames = removeString(o.ExcludedGames, id)
+	o.PinnedGames = append(removeString(o.PinnedGames, id), id)
+}
+
+// UnpinGame undoes a manual pin of id, or excludes it if it was not pinned.
+func (o *Overrides) UnpinGame(id string) {
+	for _, g := range o.PinnedGames {
+		if g == id {
+			o.PinnedGames = removeString(o.PinnedGames, id)
+			return
+		}
+	}
+	o.ExcludedGames = append(removeString(o.ExcludedGames, id), id)
+}
+
+func removePID(refs []PIDRef, pid int) []PIDRef {
+	out := refs[:0:0]
+	for _, r := range refs {
+		if r.PID != pid {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+func removeString(list []string, s string) []string {
+	out := list[:0:0]
+	for _, v := range list {
+		if v != s {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// ScopeReport records what the daemon did with leftover game-*.scope units
+// found on startup.
+type ScopeReport struct {
+	At      time.Time `json:"at"`
+	Adopted []string  `json:"adopted,omitempty"`
+	Stopped []string  `json:"stopped,omitempty"`
+	Errors  []string  `json:"errors,omitempty"`
 }
 
 func DefaultPath() (string, error) {
@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestDefaultPath_UsesXDGStateHome(t *testing.T) {
@@ -35,3 +36,53 @@ func TestSaveAndLoadRoundtrip(t *testing.T) {
 		t.Fatalf("expected state file to exist: %v", err)
 	}
 }
+
+func TestOverridesPinUnpin(t *testing.T) {
+	var o Overrides
+	o.PinPID(PIDRef{PID: 10, StartTime: 1})
+	o.PinPID(PIDRef{PID: 10, StartTime: 2})
+	if len(o.PinnedPIDs) != 1 || o.PinnedPIDs[0].StartTime != 2 {
+		t.Fatalf("unexpected pinned pids: %#v", o.PinnedPIDs)
+	}
+	o.UnpinPID(PIDRef{PID: 10})
+	if len(o.PinnedPIDs) != 0 || len(o.ExcludedPIDs) != 0 {
+		t.Fatalf("unpin of a pinned pid should only drop the pin: %#v", o)
+	}
+	o.UnpinPID(PIDRef{PID: 11, StartTime: 5})
+	if len(o.ExcludedPIDs) != 1 || o.ExcludedPIDs[0].PID != 11 {
+		t.Fatalf("unpin of a detected pid should exclude it: %#v", o.ExcludedPIDs)
+	}
+	o.PinPID(PIDRef{PID: 11, StartTime: 5})
+	if len(o.ExcludedPIDs) != 0 || len(o.PinnedPIDs) != 1 {
+		t.Fatalf("pin should lift the exclusion: %#v", o)
+	}
+
+	o.UnpinGame("570")
+	if len(o.ExcludedGames) != 1 {
+		t.Fatalf("expected 570 excluded: %#v", o.ExcludedGames)
+	}
+	o.PinGame("570")
+	if len(o.ExcludedGames) != 0 || len(o.PinnedGames) != 1 {
+		t.Fatalf("unexpected games: %#v", o)
+	}
+}
+
+func TestOverridesPause(t *testing.T) {
+	now := time.Now()
+	var o Overrides
+	if o.IsPaused(now) {
+		t.Fatalf("zero overrides should not be paused")
+	}
+	o.Pause(time.Time{})
+	if !o.IsPaused(now.Add(24 * time.Hour)) {
+		t.Fatalf("expected indefinite pause")
+	}
+	o.Pause(now.Add(time.Minute))
+	if !o.IsPaused(now) || o.IsPaused(now.Add(2*time.Minute)) {
+		t.Fatalf("unexpected timed pause")
+	}
+	o.Resume()
+	if o.IsPaused(now) {
+		t.Fatalf("expected resume")
+	}
+}
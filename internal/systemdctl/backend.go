@@ -0,0 +1,71 @@
+package systemdctl
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Backend is the unit-management surface the daemon drives. Units are named
+// the systemd way (app.slice, game-<id>.scope) regardless of implementation.
+type Backend interface {
+	Name() string
+
+	GetAllowedCPUs(ctx context.Context, unit string) (string, error)
+	SetAllowedCPUs(ctx context.Context, unit string, cpus string) error
+	StartUnit(ctx context.Context, unit string) error
+	StopUnit(ctx context.Context, unit string) error
+
+	EnsureTransientScope(ctx context.Context, scopeName string, pids []int, slice string, description string) (bool, error)
+	AttachProcessesToUnit(ctx context.Context, unit string, subcgroup string, pids []int) error
+	ListUnitsByPatterns(ctx context.Context, states []string, patterns []string) ([]UnitStatus, error)
+	GetUnitProcesses(ctx context.Context, unit string) ([]UnitProcess, error)
+
+	// CheckCPUSet returns an error describing why AllowedCPUs writes would be
+	// accepted but not enforced, or nil if they take effect.
+	CheckCPUSet() error
+
+	Close() error
+}
+
+// SystemdBackend manages units through the systemd user manager: property
+// changes go through systemctl, scopes through D-Bus.
+type SystemdBackend struct {
+	Systemctl
+	*UserManager
+}
+
+func NewSystemdBackend(dryRun bool) (*SystemdBackend, error) {
+	mgr, err := NewUserManager(dryRun)
+	if err != nil {
+		return nil, err
+	}
+	return &SystemdBackend{Systemctl: Systemctl{DryRun: dryRun}, UserManager: mgr}, nil
+}
+
+func (b *SystemdBackend) Name() string { return "systemd" }
+
+// StartUnit goes through D-Bus rather than systemctl so the job result is
+// reported back.
+func (b *SystemdBackend) StartUnit(ctx context.Context, unit string) error {
+	return b.UserManager.StartUnit(ctx, unit)
+}
+
+// CheckCPUSet verifies that the cpuset controller is delegated to the user
+// manager. Without it systemd accepts AllowedCPUs= but never applies it.
+func (b *SystemdBackend) CheckCPUSet() error {
+	uid := os.Getuid()
+	dir := fmt.Sprintf("/sys/fs/cgroup/user.slice/user-%d.slice/user@%d.service", uid, uid)
+	data, err := os.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+	if err != nil {
+		return fmt.Errorf("read controllers of user manager: %w", err)
+	}
+	for _, c := range strings.Fields(string(data)) {
+		if c == "cpuset" {
+			return nil
+		}
+	}
+	return fmt.Errorf("cpuset controller is not delegated to the user manager (%s)", dir)
+}
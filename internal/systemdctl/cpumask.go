@@ -0,0 +1,24 @@
+package systemdctl
+
+import "github.com/Reidond/ccdbind/internal/topology"
+
+// CPUMask encodes a CPU list such as "0-7,16" as the byte array systemd
+// uses for AllowedCPUs on D-Bus: bit n%8 of byte n/8 is CPU n. An empty list
+// gives an empty mask, which resets the property.
+func CPUMask(cpus string) ([]byte, error) {
+	list, err := topology.ParseCPUList(cpus)
+	if err != nil {
+		return nil, err
+	}
+	var mask []byte
+	for _, cpu := range list {
+		for len(mask) <= cpu/8 {
+			mask = append(mask, 0)
+		}
+		mask[cpu/8] |= 1 << (uint(cpu) % 8)
+	}
+	if mask == nil {
+		mask = []byte{}
+	}
+	return mask, nil
+}
@@ -0,0 +1,31 @@
+package systemdctl
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCPUMask(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []byte
+	}{
+		{"", []byte{}},
+		{"0", []byte{0x01}},
+		{"0-7", []byte{0xff}},
+		{"8-9,15", []byte{0x00, 0x83}},
+		{"1,17", []byte{0x02, 0x00, 0x02}},
+	}
+	for _, tc := range cases {
+		got, err := CPUMask(tc.in)
+		if err != nil {
+			t.Fatalf("CPUMask(%q): %v", tc.in, err)
+		}
+		if !bytes.Equal(got, tc.want) {
+			t.Fatalf("CPUMask(%q) = %#v, want %#v", tc.in, got, tc.want)
+		}
+	}
+	if _, err := CPUMask("x"); err == nil {
+		t.Fatalf("expected error for invalid list")
+	}
+}
@@ -0,0 +1,111 @@
+package systemdctl
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// JobTimeout bounds how long a job is waited for when the caller's context
+// has no deadline of its own.
+const JobTimeout = 30 * time.Second
+
+// JobError reports a systemd job that finished with a result other than
+// "done".
+type JobError struct {
+	Unit   string
+	Job    dbus.ObjectPath
+	Result string
+}
+
+func (e *JobError) Error() string {
+	msg := fmt.Sprintf("job for %s finished with result %q", e.Unit, e.Result)
+	switch e.Result {
+	case "dependency":
+		msg += ": a unit it depends on failed to start"
+	case "failed":
+		msg += fmt.Sprintf(": see 'journalctl --user -u %s'", e.Unit)
+	case "timeout":
+		msg += ": the job timed out in systemd"
+	case "canceled":
+		msg += ": the job was canceled by a conflicting job"
+	}
+	return msg
+}
+
+// jobTracker collects JobRemoved signals so callers can wait for the job
+// returned by a Manager method. Results that arrive before anyone waits are
+// kept briefly, since systemd may finish a job before the method call
+// returns to us.
+type jobTracker struct {
+	mu      sync.Mutex
+	waiters map[dbus.ObjectPath]chan string
+	done    map[dbus.ObjectPath]string
+	order   []dbus.ObjectPath
+}
+
+const jobTrackerKeep = 256
+
+func newJobTracker() *jobTracker {
+	return &jobTracker{waiters: map[dbus.ObjectPath]chan string{}, done: map[dbus.ObjectPath]string{}}
+}
+
+func (t *jobTracker) run(ch <-chan *dbus.Signal) {
+	for sig := range ch {
+		if sig == nil || sig.Name != "org.freedesktop.systemd1.Manager.JobRemoved" || len(sig.Body) < 4 {
+			continue
+		}
+		job, ok := sig.Body[1].(dbus.ObjectPath)
+		if !ok {
+			continue
+		}
+		result, _ := sig.Body[3].(string)
+		t.complete(job, result)
+	}
+}
+
+func (t *jobTracker) complete(job dbus.ObjectPath, result string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if w, ok := t.waiters[job]; ok {
+		delete(t.waiters, job)
+		w <- result
+		return
+	}
+	t.done[job] = result
+	t.order = append(t.order, job)
+	for len(t.order) > jobTrackerKeep {
+		delete(t.done, t.order[0])
+		t.order = t.order[1:]
+	}
+}
+
+func (t *jobTracker) wait(ctx context.Context, job dbus.ObjectPath) (string, error) {
+	t.mu.Lock()
+	if result, ok := t.done[job]; ok {
+		delete(t.done, job)
+		t.mu.Unlock()
+		return result, nil
+	}
+	w := make(chan string, 1)
+	t.waiters[job] = w
+	t.mu.Unlock()
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, JobTimeout)
+		defer cancel()
+	}
+	select {
+	case result := <-w:
+		return result, nil
+	case <-ctx.Done():
+		t.mu.Lock()
+		delete(t.waiters, job)
+		t.mu.Unlock()
+		return "", fmt.Errorf("waiting for job %s: %w", job, ctx.Err())
+	}
+}
@@ -0,0 +1,48 @@
+package systemdctl
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestJobTrackerResultBeforeWait(t *testing.T) {
+	tr := newJobTracker()
+	tr.complete("/org/freedesktop/systemd1/job/7", "done")
+
+	result, err := tr.wait(context.Background(), "/org/freedesktop/systemd1/job/7")
+	if err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+	if result != "done" {
+		t.Fatalf("unexpected result: %q", result)
+	}
+}
+
+func TestJobTrackerResultAfterWait(t *testing.T) {
+	tr := newJobTracker()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		tr.complete("/org/freedesktop/systemd1/job/8", "dependency")
+	}()
+
+	result, err := tr.wait(context.Background(), "/org/freedesktop/systemd1/job/8")
+	if err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+	if result != "dependency" {
+		t.Fatalf("unexpected result: %q", result)
+	}
+}
+
+func TestJobTrackerTimeout(t *testing.T) {
+	tr := newJobTracker()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := tr.wait(ctx, "/org/freedesktop/systemd1/job/9"); err == nil {
+		t.Fatalf("expected timeout")
+	}
+	if len(tr.waiters) != 0 {
+		t.Fatalf("expected waiter to be removed")
+	}
+}
@@ -23,20 +23,100 @@ type dbusAuxUnit struct {
 	Properties []dbusProperty
 }
 
+// UnitStatus mirrors one entry of the ListUnits* reply:
+// a(ssssssouso) name, description, load/active/sub state, followed unit,
+// object path, job id, job type, job path.
+type UnitStatus struct {
+	Name        string
+	Description string
+	LoadState   string
+	ActiveState string
+	SubState    string
+	Followed    string
+	Path        dbus.ObjectPath
+	JobID       uint32
+	JobType     string
+	JobPath     dbus.ObjectPath
+}
+
+// UnitProcess mirrors one entry of the GetUnitProcesses reply:
+// a(sus) control group, pid, command line.
+type UnitProcess struct {
+	Cgroup  string
+	PID     uint32
+	Cmdline string
+}
+
 type UserManager struct {
 	DryRun bool
 	conn   *dbus.Conn
+	jobs   *jobTracker
 }
 
 func NewUserManager(dryRun bool) (*UserManager, error) {
 	if dryRun {
 		return &UserManager{DryRun: true}, nil
 	}
-	conn, err := connectUserBus()
+	conn, err := ConnectUserBus()
 	if err != nil {
 		return nil, err
 	}
-	return &UserManager{conn: conn}, nil
+	m := &UserManager{conn: conn, jobs: newJobTracker()}
+	if err := m.subscribeJobs(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("subscribe to job signals: %w", err)
+	}
+	return m, nil
+}
+
+// subscribeJobs routes JobRemoved signals to the job tracker. The match must
+// be in place before any job is queued so that fast jobs are not missed.
+func (m *UserManager) subscribeJobs() error {
+	if err := m.conn.AddMatchSignal(
+		dbus.WithMatchObjectPath("/org/freedesktop/systemd1"),
+		dbus.WithMatchInterface("org.freedesktop.systemd1.Manager"),
+		dbus.WithMatchMember("JobRemoved"),
+	); err != nil {
+		return err
+	}
+	obj := m.conn.Object("org.freedesktop.systemd1", "/org/freedesktop/systemd1")
+	if call := obj.Call("org.freedesktop.systemd1.Manager.Subscribe", 0); call.Err != nil {
+		return call.Err
+	}
+	ch := make(chan *dbus.Signal, 64)
+	m.conn.Signal(ch)
+	go m.jobs.run(ch)
+	return nil
+}
+
+// waitJob blocks until job is removed and converts a non-"done" result into
+// a *JobError.
+func (m *UserManager) waitJob(ctx context.Context, unit string, job dbus.ObjectPath) error {
+	result, err := m.jobs.wait(ctx, job)
+	if err != nil {
+		return fmt.Errorf("%s: %w", unit, err)
+	}
+	if result != "done" {
+		return &JobError{Unit: unit, Job: job, Result: result}
+	}
+	return nil
+}
+
+// StartUnit starts a unit and waits for its job to finish.
+func (m *UserManager) StartUnit(ctx context.Context, unit string) error {
+	if m.DryRun {
+		log.Printf("dry-run: StartUnit(%q)", unit)
+		return nil
+	}
+	if m.conn == nil {
+		return fmt.Errorf("no dbus connection")
+	}
+	var job dbus.ObjectPath
+	obj := m.conn.Object("org.freedesktop.systemd1", "/org/freedesktop/systemd1")
+	if err := obj.CallWithContext(ctx, "org.freedesktop.systemd1.Manager.StartUnit", 0, unit, "replace").Store(&job); err != nil {
+		return err
+	}
+	return m.waitJob(ctx, unit, job)
 }
 
 func (m *UserManager) Close() error {
@@ -78,13 +158,16 @@ func (m *UserManager) EnsureTransientScope(ctx context.Context, scopeName string
 	}
 	var aux []dbusAuxUnit
 
+	var job dbus.ObjectPath
 	obj := m.conn.Object("org.freedesktop.systemd1", "/org/freedesktop/systemd1")
-	call := obj.CallWithContext(ctx, "org.freedesktop.systemd1.Manager.StartTransientUnit", 0, scopeName, "fail", props, aux)
-	if call.Err != nil {
-		if isUnitExistsErr(call.Err) {
+	if err := obj.CallWithContext(ctx, "org.freedesktop.systemd1.Manager.StartTransientUnit", 0, scopeName, "fail", props, aux).Store(&job); err != nil {
+		if isUnitExistsErr(err) {
 			return false, nil
 		}
-		return false, call.Err
+		return false, err
+	}
+	if err := m.waitJob(ctx, scopeName, job); err != nil {
+		return false, err
 	}
 	return true, nil
 }
@@ -117,6 +200,83 @@ func (m *UserManager) AttachProcessesToUnit(ctx context.Context, unit string, su
 	return call.Err
 }
 
+// ListUnitsByPatterns lists loaded units matching any of the glob patterns.
+// An empty states slice matches units in any state.
+func (m *UserManager) ListUnitsByPatterns(ctx context.Context, states []string, patterns []string) ([]UnitStatus, error) {
+	if m.DryRun {
+		log.Printf("dry-run: ListUnitsByPatterns(%v, %v)", states, patterns)
+		return nil, nil
+	}
+	if m.conn == nil {
+		return nil, fmt.Errorf("no dbus connection")
+	}
+	if states == nil {
+		states = []string{}
+	}
+	var units []UnitStatus
+	obj := m.conn.Object("org.freedesktop.systemd1", "/org/freedesktop/systemd1")
+	if err := obj.CallWithContext(ctx, "org.freedesktop.systemd1.Manager.ListUnitsByPatterns", 0, states, patterns).Store(&units); err != nil {
+		return nil, err
+	}
+	return units, nil
+}
+
+// GetUnitProcesses returns the processes currently in the unit's cgroup.
+func (m *UserManager) GetUnitProcesses(ctx context.Context, unit string) ([]UnitProcess, error) {
+	if m.DryRun {
+		log.Printf("dry-run: GetUnitProcesses(%q)", unit)
+		return nil, nil
+	}
+	if m.conn == nil {
+		return nil, fmt.Errorf("no dbus connection")
+	}
+	var procs []UnitProcess
+	obj := m.conn.Object("org.freedesktop.systemd1", "/org/freedesktop/systemd1")
+	if err := obj.CallWithContext(ctx, "org.freedesktop.systemd1.Manager.GetUnitProcesses", 0, unit).Store(&procs); err != nil {
+		return nil, err
+	}
+	return procs, nil
+}
+
+// StopUnit stops the unit and waits for its job to finish. For scopes this
+// terminates any processes still inside.
+func (m *UserManager) StopUnit(ctx context.Context, unit string) error {
+	if m.DryRun {
+		log.Printf("dry-run: StopUnit(%q)", unit)
+		return nil
+	}
+	if m.conn == nil {
+		return fmt.Errorf("no dbus connection")
+	}
+	var job dbus.ObjectPath
+	obj := m.conn.Object("org.freedesktop.systemd1", "/org/freedesktop/systemd1")
+	if err := obj.CallWithContext(ctx, "org.freedesktop.systemd1.Manager.StopUnit", 0, unit, "replace").Store(&job); err != nil {
+		return err
+	}
+	return m.waitJob(ctx, unit, job)
+}
+
+// SetUnitAllowedCPUs sets AllowedCPUs on a running unit as a runtime
+// property, so it does not outlive the unit.
+func (m *UserManager) SetUnitAllowedCPUs(ctx context.Context, unit string, cpus string) error {
+	mask, err := CPUMask(cpus)
+	if err != nil {
+		return err
+	}
+	if m.DryRun {
+		log.Printf("dry-run: SetUnitProperties(%q) AllowedCPUs=%q", unit, cpus)
+		return nil
+	}
+	if m.conn == nil {
+		return fmt.Errorf("no dbus connection")
+	}
+	props := []dbusProperty{
+		{Name: "AllowedCPUs", Value: dbus.MakeVariant(mask)},
+	}
+	obj := m.conn.Object("org.freedesktop.systemd1", "/org/freedesktop/systemd1")
+	return obj.CallWithContext(ctx, "org.freedesktop.systemd1.Manager.SetUnitProperties", 0, unit, true, props).Err
+}
+
 func isUnitExistsErr(err error) bool {
 	var de dbus.Error
 	if errors.As(err, &de) {
@@ -125,7 +285,10 @@ func isUnitExistsErr(err error) bool {
 	return false
 }
 
-func connectUserBus() (*dbus.Conn, error) {
+// ConnectUserBus connects to the user's session bus, falling back to the
+// well-known $XDG_RUNTIME_DIR/bus socket when DBUS_SESSION_BUS_ADDRESS is
+// unset (e.g. under systemd --user).
+func ConnectUserBus() (*dbus.Conn, error) {
 	// First try the standard session bus connection.
 	if os.Getenv("DBUS_SESSION_BUS_ADDRESS") != "" {
 		conn, err := dbus.ConnectSessionBus()